@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"poorman-faas/pkg/audit"
+	"strconv"
+	"time"
+)
+
+// parseAuditFilter reads `since`, `until` (RFC 3339), `actor`, `service`, `action` and `limit` from the query string.
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:   query.Get("actor"),
+		Service: query.Get("service"),
+		Action:  audit.Action(query.Get("action")),
+	}
+	for key, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return audit.Filter{}, fmt.Errorf("query %s: %w", key, err)
+		}
+		*t = parsed
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return audit.Filter{}, fmt.Errorf("limit %q MUST be a positive integer", value)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// getAuditHandler lists the audited administrative actions, in chronological order.
+func getAuditHandler(auditor *audit.Auditor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		events, err := auditor.Query(filter)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("auditor.Query(): %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(events)
+	}
+}
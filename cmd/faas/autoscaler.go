@@ -0,0 +1,52 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"poorman-faas/pkg"
+	"poorman-faas/pkg/autoscaler"
+	pkg_reaper "poorman-faas/pkg/reaper"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// autoscalerTargets returns the bounds of the functions that declared max_replicas, capped by the gateway.
+func autoscalerTargets(cfg pkg.Config, reaper *pkg_reaper.Reaper) map[string]autoscaler.Bounds {
+	targets := make(map[string]autoscaler.Bounds)
+	for _, service := range reaper.Services() {
+		p := reaper.Policy(service)
+		if p.MaxReplicas <= 0 {
+			continue
+		}
+		maxReplicas := int32(min(p.MaxReplicas, cfg.AutoscalerMaxReplicas))
+		targets[service] = autoscaler.Bounds{
+			Min:               min(int32(max(p.MinReplicas, 1)), maxReplicas),
+			Max:               maxReplicas,
+			TargetConcurrency: cmp.Or(p.TargetConcurrency, cfg.AutoscalerTargetConcurrency),
+		}
+	}
+	return targets
+}
+
+// getAutoscalerHandler returns the state of the autoscaler for a function, and its recent scale decisions.
+//
+// `?revision=` picks a revision of a name other than the oldest.
+func getAutoscalerHandler(scaler *autoscaler.Autoscaler, reaper *pkg_reaper.Reaper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := chi.URLParam(r, "svcName")
+		service, err := lookupRevision(reaper, route, r.URL.Query().Get("revision"))
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+		status, exists := scaler.Status(service)
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("function %q is not autoscaled", route))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"poorman-faas/pkg/invocation"
+	pkg_reaper "poorman-faas/pkg/reaper"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// getInvocationsHandler lists the recent invocations of a function, newest first.
+//
+// `?limit=N` returns at most N invocations, and `?revision=` picks a revision of a name other than the oldest.
+func getInvocationsHandler(store invocation.Store, reaper *pkg_reaper.Reaper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := chi.URLParam(r, "svcName")
+		service, err := lookupRevision(reaper, route, r.URL.Query().Get("revision"))
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+		limit := 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("limit %q MUST be a positive integer", value))
+				return
+			}
+		}
+
+		records, err := store.List(service, limit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("store.List(): %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(records)
+	}
+}
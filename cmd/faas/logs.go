@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"poorman-faas/pkg"
+	pkg_reaper "poorman-faas/pkg/reaper"
+	"poorman-faas/pkg/util"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// parseLogOptions reads `follow`, `tail`, `since_seconds` and `previous` from the query string.
+func parseLogOptions(r *http.Request) (util.LogOptions, error) {
+	var opts util.LogOptions
+	query := r.URL.Query()
+	for key, parse := range map[string]func(string) error{
+		"follow": func(value string) (err error) {
+			opts.Follow, err = strconv.ParseBool(value)
+			return err
+		},
+		"previous": func(value string) (err error) {
+			opts.Previous, err = strconv.ParseBool(value)
+			return err
+		},
+		"tail": func(value string) (err error) {
+			opts.TailLines, err = strconv.ParseInt(value, 10, 64)
+			return err
+		},
+		"since_seconds": func(value string) (err error) {
+			opts.SinceSeconds, err = strconv.ParseInt(value, 10, 64)
+			return err
+		},
+	} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+		if err := parse(value); err != nil {
+			return util.LogOptions{}, fmt.Errorf("query %s: %w", key, err)
+		}
+	}
+	if opts.TailLines < 0 || opts.SinceSeconds < 0 {
+		return util.LogOptions{}, fmt.Errorf("tail and since_seconds must not be negative")
+	}
+	return opts, nil
+}
+
+// getLogsHandler streams the container logs of all the pods of a function, each line prefixed with its pod name.
+//
+// It responds with chunked plain text, or with Server-Sent Events if the client accepts text/event-stream.
+// `?revision=` picks a revision of a name other than the oldest.
+func getLogsHandler(config pkg.Config, reaper *pkg_reaper.Reaper, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := chi.URLParam(r, "svcName")
+		service, err := lookupRevision(reaper, route, r.URL.Query().Get("revision"))
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+		opts, err := parseLogOptions(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		rc := http.NewResponseController(w)
+		wroteHeader := false
+		emit := func(pod string, line string) {
+			if !wroteHeader {
+				wroteHeader = true
+				if sse {
+					w.Header().Set("Content-Type", "text/event-stream")
+					w.Header().Set("Cache-Control", "no-cache")
+				} else {
+					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				}
+				w.Header().Set("X-Content-Type-Options", "nosniff")
+				w.WriteHeader(http.StatusOK)
+			}
+			if sse {
+				_, _ = fmt.Fprintf(w, "event: log\ndata: [%s] %s\n\n", pod, line)
+			} else {
+				_, _ = fmt.Fprintf(w, "[%s] %s\n", pod, line)
+			}
+			_ = rc.Flush()
+		}
+
+		err = util.StreamServiceLogs(r.Context(), config.K8SClientset, config.K8sNamespace, service, opts, emit)
+		switch {
+		case errors.Is(err, util.ErrServiceNotFound) && !wroteHeader:
+			writeJSONError(w, http.StatusNotFound, err)
+		case err != nil && !wroteHeader:
+			writeJSONError(w, http.StatusInternalServerError, err)
+		case err != nil:
+			// the status line is already sent, so report the error in band
+			logger.Warn("Failed to stream logs", "service", service, "error", err)
+			emit("faas", fmt.Sprintf("error: %v", err))
+		case !wroteHeader:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
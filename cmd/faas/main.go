@@ -2,6 +2,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -10,8 +11,19 @@ import (
 	"os"
 	"os/signal"
 	"poorman-faas/pkg"
+	"poorman-faas/pkg/audit"
+	"poorman-faas/pkg/autoscaler"
+	"poorman-faas/pkg/helm"
+	"poorman-faas/pkg/invocation"
+	"poorman-faas/pkg/mcp"
+	"poorman-faas/pkg/metrics"
+	"poorman-faas/pkg/policy"
 	"poorman-faas/pkg/proxy"
 	pkg_reaper "poorman-faas/pkg/reaper"
+	"poorman-faas/pkg/rollout"
+	"poorman-faas/pkg/tracing"
+	"poorman-faas/pkg/traffic"
+	"poorman-faas/pkg/util"
 	"syscall"
 	"time"
 
@@ -20,49 +32,327 @@ import (
 	"github.com/go-chi/httprate"
 )
 
+// auditMemorySize is how many audit events are kept when there is no audit log file.
+const auditMemorySize = 10000
+
+// resolverSyncTimeout bounds listing the pods of functions at startup.
+const resolverSyncTimeout = 30 * time.Second
+
+// trafficPersistTimeout bounds saving the weight of a revision in its policy.
+const trafficPersistTimeout = 10 * time.Second
+
+// rateLimits returns the limits of a request to the route, the ones declared by the function or the gateway defaults.
+//
+// The limits of a name are shared by its revisions, so that a canary does not add to them.
+func rateLimits(cfg pkg.Config, route string, owner string, p policy.Policy, r *http.Request) []proxy.Limit {
+	// callers behind the same ingress share its address, so they are told apart by their credentials first
+	caller := cmp.Or(util.CredentialFingerprint(r), util.ClientIP(r))
+	limits := []proxy.Limit{
+		{Key: "caller:" + route + ":" + caller, Rate: p.CallerRateLimit.Or(cfg.RateLimitCaller)},
+		{Key: "function:" + route, Rate: p.RateLimit.Or(cfg.RateLimitFunction)},
+	}
+	if owner != "" {
+		limits = append(limits, proxy.Limit{Key: "owner:" + owner, Rate: cfg.RateLimitOwner})
+	}
+	return limits
+}
+
 func run(ctx context.Context, cfg pkg.Config, logger *slog.Logger) error {
+	// audit administrative actions, in a file if configured
+	var sink audit.Sink = audit.NewMemorySink(auditMemorySize)
+	if cfg.AuditLogPath != "" {
+		fileSink, err := audit.NewFileSink(cfg.AuditLogPath)
+		if err != nil {
+			return fmt.Errorf("audit.NewFileSink(): %w", err)
+		}
+		defer fileSink.Close()
+		sink = fileSink
+	}
+	auditor := audit.New(sink, logger)
+
 	// initialize the reaper (which also hydrates from existing cluster resources)
 	// for debugging, we set a very short time to live and a very short poll every
-	reaper := pkg_reaper.New(ctx, cfg.ReaperPollEvery, cfg.ReaperTimeToLive, cfg.K8SClientset, cfg.K8sNamespace, logger)
+	reaper := pkg_reaper.New(ctx, cfg.ReaperPollEvery, cfg.ReaperTimeToLive, cfg.K8SClientset, cfg.K8sNamespace, logger, pkg_reaper.WithAuditor(auditor))
+	// recent invocations of each function, forgotten once the function is culled
+	invocations := invocation.NewRing(cfg.InvocationHistorySize)
+	reaper.OnCull(invocations.Forget)
+	// requests served and waiting for each function
+	concurrency := proxy.NewConcurrencyLimiter(func(service string, inFlight int, queued int) {
+		metrics.ProxyInFlight.WithLabelValues(service).Set(float64(inFlight))
+		metrics.ProxyQueueDepth.WithLabelValues(service).Set(float64(queued))
+	})
+	reaper.OnCull(concurrency.Forget)
+	reaper.OnCull(metrics.ForgetService)
+	// scale the functions that declared max_replicas with their concurrency
+	scaler := autoscaler.New(
+		autoscaler.NewDeploymentScaler(cfg.K8SClientset, cfg.K8sNamespace, reaper.DeploymentName),
+		func(service string) float64 {
+			inFlight, queued := concurrency.Load(service)
+			return float64(inFlight + queued)
+		},
+		func() map[string]autoscaler.Bounds {
+			return autoscalerTargets(cfg, reaper)
+		},
+		logger,
+		autoscaler.WithTick(cfg.AutoscalerTick),
+		autoscaler.WithStableWindow(cfg.AutoscalerStableWindow),
+		autoscaler.WithPanicWindow(cfg.AutoscalerPanicWindow),
+		autoscaler.WithPanicThreshold(cfg.AutoscalerPanicThreshold),
+	)
+	reaper.OnCull(scaler.Forget)
+	util.MustGo(func() {
+		scaler.Run(ctx)
+	})
+
+	// names with several revisions split their traffic, see pkg/traffic
+	splitter := traffic.New(reaper.Revisions,
+		func(service string) traffic.Declared {
+			p := reaper.Policy(service)
+			declared := traffic.Declared{Weight: -1, RolledBack: p.RolledBack}
+			if p.Weight != nil {
+				declared.Weight = *p.Weight
+			}
+			return declared
+		},
+		traffic.WithCanaryWeight(cfg.CanaryWeight),
+		traffic.WithPersist(func(service string, declared traffic.Declared) error {
+			ctx, cancel := context.WithTimeout(ctx, trafficPersistTimeout)
+			defer cancel()
+			p := reaper.Policy(service)
+			p.Weight, p.RolledBack = &declared.Weight, declared.RolledBack
+			return reaper.SetPolicy(ctx, service, p)
+		}),
+		traffic.WithRollback(cfg.CanaryRollbackErrorRate, cfg.CanaryRollbackMinRequests, cfg.CanaryRollbackWindow, func(rollback traffic.Rollback) {
+			logger.Warn("Rolled back canary", "name", rollback.Name, "service", rollback.Service, "error_rate", rollback.ErrorRate, "requests", rollback.Requests)
+			if rollback.Err != nil {
+				logger.Error("Failed to persist the rollback, it is lost on restart", "service", rollback.Service, "error", rollback.Err)
+			}
+			auditor.Record(audit.Event{
+				Actor:   audit.ActorSystem,
+				Action:  audit.ActionRollback,
+				Service: rollback.Service,
+				Name:    rollback.Name,
+				Reason:  fmt.Sprintf("error rate %.2f over %d requests", rollback.ErrorRate, rollback.Requests),
+			})
+		}),
+	)
+	reaper.OnCull(splitter.Forget)
 
 	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
 	r.Use(httplog.RequestLogger(logger, nil))
 	// admin routes: this creates faas service.
 	{
 		admin := chi.NewRouter()
 
-		// because this creates k8s resource, we are extra careful.
-		// for example, see e2b create sandbox rate limit at 5/second.
-		admin.Use(httprate.LimitByIP(10, time.Minute))
-		admin.Post("/python", getUploadHandler(cfg, reaper, logger))
+		tracker := rollout.NewTracker(time.Hour)
+		admin.Group(func(admin chi.Router) {
+			// because this creates k8s resource, we are extra careful.
+			// for example, see e2b create sandbox rate limit at 5/second.
+			admin.Use(httprate.LimitByIP(10, time.Minute))
+			admin.Post("/python", getUploadHandler(cfg, reaper, tracker, auditor, logger))
+			admin.Put("/traffic/{name}", putTrafficHandler(splitter, reaper, auditor))
+		})
+		// read only routes are polled, so they are not rate limited
+		admin.Get("/deployments/{id}", getDeploymentHandler(tracker))
+		admin.Get("/python/{svcName}/logs", getLogsHandler(cfg, reaper, logger))
+		admin.Get("/python/{svcName}/invocations", getInvocationsHandler(invocations, reaper))
+		admin.Get("/python/{svcName}/autoscaler", getAutoscalerHandler(scaler, reaper))
+		admin.Get("/audit", getAuditHandler(auditor))
+		admin.Get("/traffic/{name}", getTrafficHandler(splitter, reaper))
 		r.Mount("/admin", admin)
 	}
 	// gateway routes: this proxies to the faas service.
 	{
 		gateway := chi.NewRouter()
 		namespace := cfg.K8sNamespace
-		getServiceName := func(r *http.Request) string {
+		getRoute := func(r *http.Request) string {
 			// return r.PathValue("svcName")
 			return chi.URLParam(r, "svcName")
 		}
+		// lookup returns the service of the request, the revision picked by the splitter if its name is split
+		lookup := func(r *http.Request) (string, bool) {
+			if service, picked := traffic.Picked(r); picked {
+				return reaper.Lookup(service)
+			}
+			return reaper.Lookup(getRoute(r))
+		}
+		getServiceName := func(r *http.Request) string {
+			service, _ := lookup(r)
+			return service
+		}
+		// the invocations are served by the admin API, so their bodies are redacted like the access log
+		recorder := invocation.NewRecorder(invocations, cfg.InvocationPayloadBytes, func(err error) {
+			logger.Error("Failed to record invocation", "error", err)
+		}, invocation.WithRedact(proxy.NewRedactor(cfg.AccessLogRedactFields...).Body))
+		// fail fast once a function keeps failing, and retry the requests that did not reach it
+		breaker := proxy.NewCircuitBreaker(cfg.CircuitBreakerFailures, cfg.CircuitBreakerCooldown, func(service string, state proxy.CircuitState) {
+			logger.Warn("Circuit changed state", "service", service, "state", state.String())
+			metrics.ProxyCircuitState.WithLabelValues(service).Set(float64(state))
+		})
+		reaper.OnCull(breaker.Forget)
+		// spread requests over the ready pods of functions, through kube-proxy until their pods are known
+		resolver := proxy.NewResolver()
+		if cfg.LoadBalancing != "" {
+			if err := resolver.Watch(ctx, cfg.K8SClientset, namespace, helm.LabelManagedBy+"=true", resolverSyncTimeout); err != nil {
+				// i.e. the role of the gateway cannot watch endpointslices yet
+				logger.Error("Failed to watch the pods of functions, requests go through their Services", "error", err)
+			}
+		}
+		reaper.OnCull(resolver.Forget)
+		balanced := resolver.Transport(proxy.ProxyTransport(), func(r *http.Request) (string, proxy.Balancing) {
+			if cfg.LoadBalancing == "" || mcp.Endpoint(r) != "" {
+				// MCP sessions are pinned to their pod
+				return "", proxy.Balancing{}
+			}
+			service := getServiceName(r)
+			p := reaper.Policy(service)
+			return service, proxy.Balancing{
+				Strategy:   proxy.Strategy(cmp.Or(p.LoadBalancing, cfg.LoadBalancing)),
+				HashHeader: cmp.Or(p.HashHeader, cfg.LoadBalancingHashHeader),
+			}
+		})
+		retries := proxy.RetryTransport(balanced,
+			func(r *http.Request) proxy.Retry {
+				return proxy.Retry{
+					Attempts:     cmp.Or(reaper.Policy(getServiceName(r)).Retries, cfg.Retries) + 1,
+					Backoff:      cfg.RetryBackoff,
+					MaxBodyBytes: cfg.RetryMaxBodyBytes,
+				}
+			},
+			func(r *http.Request, attempt int, err error) {
+				service := getServiceName(r)
+				logger.Warn("Retrying request", "service", service, "attempt", attempt, "error", err)
+				metrics.ProxyRetries.WithLabelValues(service).Inc()
+			},
+		)
+		// sessions of MCP functions are pinned to the pod that issued them
+		mcpGateway := mcp.New(func(ctx context.Context, service string) ([]string, error) {
+			if endpoints := resolver.Endpoints(service); len(endpoints) > 0 {
+				return endpoints, nil
+			}
+			return util.ListEndpoints(ctx, cfg.K8SClientset, namespace, service)
+		}, logger, mcp.WithSessionTTL(cfg.MCPSessionTTL))
+		reaper.OnCull(mcpGateway.Forget)
+		// functions stay alive while they stream events or hold WebSockets
+		keepAlive := proxy.KeepAlive(cfg.ReaperTimeToLive/2, func(r *http.Request) {
+			reaper.MustUpdate(r.Context(), getServiceName(r))
+		})
 		rp, err := proxy.New(
-			proxy.WithTransport(proxy.ProxyTransport()),
+			proxy.WithTransport(tracing.Transport(breaker.Transport(
+				proxy.TimeoutTransport(retries, func(r *http.Request) time.Duration {
+					return min(reaper.Policy(getServiceName(r)).Timeout.Or(cfg.RequestTimeout), cfg.RequestTimeoutCap)
+				}),
+				getServiceName,
+			))),
 			proxy.WithRewrites(
-				proxy.RewriteURL(cfg.GatewayPathPrefix, namespace, getServiceName),
-				proxy.DebugRequest(logger),
+				proxy.RewriteURL(cfg.GatewayPathPrefix, namespace, getRoute, getServiceName),
+				proxy.RewriteHost(mcp.Endpoint),
 			),
 			proxy.WithModifyResponse(func(r *http.Response) error {
 				svcName := getServiceName(r.Request)
 				reaper.MustUpdate(r.Request.Context(), svcName)
-				return nil
+				if err := keepAlive(r); err != nil {
+					return err
+				}
+				if err := mcpGateway.ModifyResponse(r); err != nil {
+					return err
+				}
+				return recorder.ModifyResponse(r)
 			}),
+			proxy.WithFlushInterval(-1),
+			proxy.WithErrorHandler(logger,
+				proxy.RetryAfter(cfg.GatewayRetryAfter),
+				proxy.DetectReady(func(r *http.Request) bool {
+					return reaper.Responded(getServiceName(r))
+				}),
+				proxy.OnError(func(r *http.Request, err error) {
+					invocation.RecordError(r, err)
+					mcpGateway.OnError(r, err)
+				}),
+			),
 		)
 		if err != nil {
 			return fmt.Errorf("proxy.New(): %w", err)
 		}
-		gateway.Handle("/{svcName}/*", rp)
+		getLabels := func(r *http.Request) (string, string) {
+			service, exists := lookup(r)
+			if !exists {
+				return metrics.UnknownService, ""
+			}
+			return service, reaper.Owner(service)
+		}
+		accessLogOpts := []proxy.AccessLogOption{
+			proxy.LogBodies(cfg.AccessLogBodyBytes),
+			proxy.RedactHeaders(cfg.AccessLogRedactHeaders...),
+			proxy.RedactFields(cfg.AccessLogRedactFields...),
+			proxy.DetectColdStart(reaper.FirstInvocation),
+		}
+		if cfg.AccessLogHeaders {
+			accessLogOpts = append(accessLogOpts, proxy.LogHeaders())
+		}
+		gateway.With(
+			splitter.Middleware(getRoute),
+			metrics.InstrumentProxy(getLabels),
+			proxy.RejectUnknown(func(r *http.Request) bool {
+				_, exists := lookup(r)
+				return exists
+			}),
+			proxy.LimitBody(func(r *http.Request) int64 {
+				service, _ := lookup(r)
+				return min(cmp.Or(reaper.Policy(service).MaxBodyBytes, cfg.RequestMaxBodyBytes), cfg.RequestMaxBodyBytesCap)
+			}),
+			proxy.AccessLog(logger, getLabels, accessLogOpts...),
+			mcpGateway.Middleware(func(r *http.Request) (string, bool) {
+				service, _ := lookup(r)
+				return service, reaper.Policy(service).MCP
+			}),
+			proxy.RateLimit(func(r *http.Request) []proxy.Limit {
+				service, _ := lookup(r)
+				return rateLimits(cfg, cmp.Or(reaper.Name(service), service), reaper.Owner(service), reaper.Policy(service), r)
+			}),
+			concurrency.Middleware(func(r *http.Request) (string, proxy.Concurrency) {
+				service, _ := lookup(r)
+				p := reaper.Policy(service)
+				// the replicas added by the autoscaler serve their share of the queue
+				return service, proxy.Concurrency{
+					MaxInFlight:  cmp.Or(p.MaxInFlight, cfg.ConcurrencyMaxInFlight),
+					Replicas:     int(scaler.CurrentReplicas(service)),
+					QueueSize:    cmp.Or(p.QueueSize, cfg.ConcurrencyQueueSize),
+					QueueTimeout: p.QueueTimeout.Or(cfg.ConcurrencyQueueTimeout),
+				}
+			}),
+			recorder.Middleware(func(r *http.Request) (string, bool) {
+				return lookup(r)
+			}),
+		).Handle("/{svcName}/*", rp)
 		r.Mount(cfg.GatewayPathPrefix, gateway)
 	}
+	// add the aggregated MCP server of each user, its calls go through the gateway
+	{
+		aggregator := mcp.NewAggregator(
+			func(user string) []mcp.Function {
+				return mcpFunctions(reaper, user)
+			},
+			r,
+			func(route string) string {
+				return cfg.GatewayPathPrefix + "/" + route + cfg.MCPPath
+			},
+			logger,
+			mcp.WithBackendSessionTTL(cfg.MCPSessionTTL),
+			mcp.WithMaxResponseBytes(cfg.RequestMaxBodyBytesCap),
+		)
+		reaper.OnCull(aggregator.Forget)
+		r.With(proxy.LimitBody(func(*http.Request) int64 {
+			return cfg.RequestMaxBodyBytes
+		})).Handle("/mcp/{user}", aggregator.Handler(func(r *http.Request) string {
+			return chi.URLParam(r, "user")
+		}))
+	}
+	// add metrics route
+	{
+		r.Handle("/metrics", metrics.Handler())
+	}
 	// add health check route
 	{
 		r.Handle("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -110,8 +400,27 @@ func main() {
 		panic(err)
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	level, err := config.SlogLevel()
+	if err != nil {
+		panic(err)
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 	ctx := context.Background()
+	shutdownTracing, err := tracing.Setup(
+		tracing.WithServiceName(config.OtelServiceName),
+		tracing.WithSampleRatio(config.OtelSampleRatio),
+		tracing.WithOTLP(ctx, config.OtelTracesEndpoint),
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		// flush pending spans
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("Failed to shut down tracing", "error", err)
+		}
+	}()
+
 	err = run(ctx, config, logger)
 	if err != nil {
 		panic(err)
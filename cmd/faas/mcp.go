@@ -0,0 +1,34 @@
+package main
+
+import (
+	"cmp"
+	"poorman-faas/pkg/mcp"
+	pkg_reaper "poorman-faas/pkg/reaper"
+)
+
+// mcpFunctions returns the functions of the user that declared mcp, routed by their name if they have one.
+//
+// A name lists the tools of a single revision, the oldest one that declared mcp, as its canaries share the route.
+func mcpFunctions(reaper *pkg_reaper.Reaper, user string) []mcp.Function {
+	var functions []mcp.Function
+	seen := make(map[string]bool)
+	for _, service := range reaper.Services() {
+		name := reaper.Name(service)
+		revisions := []string{service}
+		if name != "" {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			revisions = reaper.Revisions(name)
+		}
+		for _, revision := range revisions {
+			if user == "" || reaper.Owner(revision) != user || !reaper.Policy(revision).MCP {
+				continue
+			}
+			functions = append(functions, mcp.Function{Service: revision, Route: cmp.Or(name, revision)})
+			break
+		}
+	}
+	return functions
+}
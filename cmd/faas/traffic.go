@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"poorman-faas/pkg/audit"
+	pkg_reaper "poorman-faas/pkg/reaper"
+	"poorman-faas/pkg/traffic"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// TrafficRequest shifts the traffic of a name between its revisions.
+type TrafficRequest struct {
+	User string `json:"user"`
+	// Weights of the revisions by service, the revisions left out keep theirs.
+	Weights map[string]int `json:"weights"`
+}
+
+type TrafficResponse struct {
+	Name      string             `json:"name"`
+	Revisions []traffic.Revision `json:"revisions"`
+}
+
+// getTrafficHandler returns how the traffic of a name is split between its revisions, oldest first.
+func getTrafficHandler(splitter *traffic.Splitter, reaper *pkg_reaper.Reaper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if len(reaper.Revisions(name)) == 0 {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("name %q not found", name))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(TrafficResponse{Name: name, Revisions: splitter.Revisions(name, time.Now())})
+	}
+}
+
+// putTrafficHandler shifts the traffic of a name between its revisions, i.e. to promote or roll back a canary.
+func putTrafficHandler(splitter *traffic.Splitter, reaper *pkg_reaper.Reaper, auditor *audit.Auditor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if len(reaper.Revisions(name)) == 0 {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("name %q not found", name))
+			return
+		}
+		var req TrafficRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("json.Decode(): %w", err))
+			return
+		}
+		if len(req.Weights) == 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("weights MUST NOT be empty"))
+			return
+		}
+
+		err := splitter.SetWeights(name, req.Weights)
+		services := make([]string, 0, len(req.Weights))
+		for service := range req.Weights {
+			services = append(services, service)
+		}
+		slices.Sort(services)
+		for _, service := range services {
+			event := audit.Event{Action: audit.ActionUpdate, Service: service, Name: name, Reason: fmt.Sprintf("weight %d", req.Weights[service])}
+			event.FromRequest(r, req.User)
+			if err != nil {
+				event.Fail(err)
+			}
+			auditor.Record(event)
+		}
+		if errors.Is(err, traffic.ErrNotPersisted) {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("splitter.SetWeights(): %w", err))
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("splitter.SetWeights(): %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(TrafficResponse{Name: name, Revisions: splitter.Revisions(name, time.Now())})
+	}
+}
+
+// lookupRevision resolves the route of an admin request to a service, the oldest revision of a name,
+// or the given revision of it if not empty, i.e. to inspect a canary.
+func lookupRevision(reaper *pkg_reaper.Reaper, route string, revision string) (string, error) {
+	service, exists := reaper.Lookup(route)
+	if !exists {
+		return "", fmt.Errorf("function %q not found", route)
+	}
+	if revision == "" || revision == service {
+		return service, nil
+	}
+	if !slices.Contains(reaper.Revisions(route), revision) {
+		return "", fmt.Errorf("%q is not a revision of %q", revision, route)
+	}
+	return revision, nil
+}
@@ -1,42 +1,371 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"poorman-faas/pkg"
+	"poorman-faas/pkg/audit"
 	"poorman-faas/pkg/helm"
+	"poorman-faas/pkg/metrics"
+	"poorman-faas/pkg/policy"
 	pkg_reaper "poorman-faas/pkg/reaper"
+	"poorman-faas/pkg/rollout"
+	"poorman-faas/pkg/source"
 	"poorman-faas/pkg/util"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// maxUploadSize caps the JSON body, leaving room for base64 overhead over [helm.MaxBundleSize].
+const maxUploadSize = 2 * helm.MaxBundleSize
+
+// diagnoseTimeout bounds collecting the logs and events of a failed deployment.
+const diagnoseTimeout = 10 * time.Second
+
 type UploadOption struct {
 	User    string `json:"user"`
 	Replica int    `json:"replica"`
+	// Name is an optional human-readable name, unique within the namespace.
+	// The function is then also reachable at /gateway/{name}.
+	Name string `json:"name"`
+	// Canary deploys the function as a new revision of the existing Name, instead of failing.
+	// It receives a share of the traffic of the name relative to its policy weight, a small one if it declares none.
+	Canary bool `json:"canary"`
+	// Policy is how the gateway serves the function, i.e. its rate limits.
+	Policy policy.Policy `json:"policy"`
 }
 
 type UploadRequest struct {
-	Script  string       `json:"script"`
+	// Script is a base64 encoded single file Python script.
+	Script string `json:"script"`
+	// Bundle is a base64 encoded zip or tar.gz archive, mutually exclusive with Script.
+	Bundle string `json:"bundle"`
+	// Entrypoint is the path of the script to run inside Bundle, defaults to main.py.
+	Entrypoint string `json:"entrypoint"`
+	// Source fetches the code server side, mutually exclusive with Script and Bundle.
+	Source *source.Spec `json:"source"`
+	// Image runs a prebuilt container image instead of Python code.
+	Image   *helm.Image  `json:"image"`
 	DotFile string       `json:"dot_file"`
 	Option  UploadOption `json:"option"`
 }
 
+// validate checks that at most one kind of function is requested, along with its options.
+func (req UploadRequest) validate() error {
+	set := 0
+	for _, isSet := range []bool{req.Script != "", req.Bundle != "", req.Source != nil, req.Image != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("script, bundle, source and image are mutually exclusive")
+	}
+	if req.Option.Canary && req.Option.Name == "" {
+		return fmt.Errorf("a canary MUST have a name")
+	}
+	if err := req.Option.Policy.Validate(); err != nil {
+		return fmt.Errorf("policy: %w", err)
+	}
+	return nil
+}
+
+// bundle returns the files to deploy, from either the script, the archive or the remote source.
+func (req UploadRequest) bundle(ctx context.Context, fetcher *source.Fetcher) (source.Result, error) {
+	var (
+		bundle helm.Bundle
+		err    error
+	)
+	switch {
+	case req.Source != nil:
+		return fetcher.Fetch(ctx, *req.Source)
+	case req.Bundle != "":
+		bundle, err = helm.NewArchiveBundle(req.Bundle, req.Entrypoint)
+	case req.Entrypoint != "":
+		return source.Result{}, fmt.Errorf("entrypoint requires a bundle")
+	default:
+		bundle, err = helm.NewScriptBundle(req.Script)
+	}
+	return source.Result{Bundle: bundle}, err
+}
+
 type UploadResponse struct {
 	URL     string `json:"url"`
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	// DeploymentID polls the rollout at /admin/deployments/{id}.
+	DeploymentID string `json:"deployment_id,omitempty"`
+	// Details explains why the deployment did not become ready.
+	Details *util.Diagnosis `json:"details,omitempty"`
+}
+
+// uploadError carries the HTTP status code an upload failed with.
+type uploadError struct {
+	code int
+	err  error
+	// details is the state of the pods when the deployment did not become ready
+	details *util.Diagnosis
+	// reason classifies the failure for metrics, defaults to the status code class
+	reason string
+}
+
+func (e *uploadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// statusCodeOf returns the HTTP status code of an upload failure, defaults to 500.
+func statusCodeOf(err error) int {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.code
+	}
+	return http.StatusInternalServerError
+}
+
+// failureReasonOf classifies an upload failure for [metrics.UploadFailures].
+func failureReasonOf(err error) string {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) && uploadErr.reason != "" {
+		return uploadErr.reason
+	}
+	switch statusCodeOf(err) {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusConflict:
+		return "conflict"
+	default:
+		return "internal"
+	}
+}
+
+// detailsOf returns the diagnosis of an upload failure, if any.
+func detailsOf(err error) *util.Diagnosis {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.details
+	}
+	return nil
+}
+
+// uploader runs the upload pipeline, shared by synchronous and asynchronous uploads.
+type uploader struct {
+	config  pkg.Config
+	fetcher *source.Fetcher
+	reaper  *pkg_reaper.Reaper
+	auditor *audit.Auditor
+	logger  *slog.Logger
+	// names reserved by in-flight uploads, so that two uploads cannot claim the same name
+	pendingMu sync.Mutex
+	pending   map[string]struct{}
+}
+
+func (u *uploader) reserveName(name string) bool {
+	u.pendingMu.Lock()
+	defer u.pendingMu.Unlock()
+	if _, exists := u.pending[name]; exists {
+		return false
+	}
+	u.pending[name] = struct{}{}
+	return true
+}
+
+func (u *uploader) releaseName(name string) {
+	u.pendingMu.Lock()
+	defer u.pendingMu.Unlock()
+	delete(u.pending, name)
+}
+
+// admit runs the cheap checks of the request before it is accepted, and reserves its name.
+//
+// release MUST be called once the upload is done.
+func (u *uploader) admit(ctx context.Context, req UploadRequest) (release func(), err error) {
+	if err := req.validate(); err != nil {
+		return nil, &uploadError{code: http.StatusBadRequest, err: fmt.Errorf("req.validate(): %w", err)}
+	}
+	if req.Image != nil {
+		if err := req.Image.Validate(u.config.ImageAllowedRegistries); err != nil {
+			return nil, &uploadError{code: http.StatusBadRequest, err: fmt.Errorf("req.Image.Validate(): %w", err)}
+		}
+	}
+
+	// enforce name uniqueness within the namespace
+	name := req.Option.Name
+	if name == "" {
+		return func() {}, nil
+	}
+	if !u.reserveName(name) {
+		return nil, &uploadError{code: http.StatusConflict, err: fmt.Errorf("name %q is being deployed", name)}
+	}
+	existing, err := helm.FindServiceByName(ctx, u.config.K8SClientset, u.config.K8sNamespace, name)
+	if err != nil {
+		u.releaseName(name)
+		return nil, fmt.Errorf("helm.FindServiceByName(): %w", err)
+	}
+	// a canary is a new revision of the name
+	if existing != "" && !req.Option.Canary {
+		u.releaseName(name)
+		return nil, &uploadError{code: http.StatusConflict, err: fmt.Errorf("name %q is already taken by %s", name, existing)}
+	}
+	return func() { u.releaseName(name) }, nil
+}
+
+// chart fetches the code of the request and templates the helm chart, the request MUST be admitted.
+func (u *uploader) chart(ctx context.Context, req UploadRequest) (helm.Chart, error) {
+	var (
+		chart helm.Chart
+		err   error
+	)
+	opts := []helm.ChartOption{helm.WithName(req.Option.Name), helm.WithOwner(req.Option.User), helm.WithPolicy(req.Option.Policy)}
+	if req.Image != nil {
+		chart, err = helm.NewImageChart(u.config.K8sNamespace, *req.Image, req.DotFile, opts...)
+	} else {
+		code, bundleErr := req.bundle(ctx, u.fetcher)
+		if bundleErr != nil {
+			return helm.Chart{}, &uploadError{code: http.StatusBadRequest, err: fmt.Errorf("req.bundle(): %w", bundleErr)}
+		}
+		opts = append(opts, helm.WithSource(code.Origin, code.Digest))
+		chart, err = helm.NewChart(u.config.K8sNamespace, code.Bundle, req.DotFile, opts...)
+	}
+	if err != nil {
+		return helm.Chart{}, fmt.Errorf("helm.NewChart(): %w", err)
+	}
+	return chart, nil
+}
+
+// upload templates and deploys the chart of the admitted request, and reports its progress to the job.
+// It returns the gateway URL of the function once it is ready.
+//
+// The event is audited once done, with the function filled in.
+func (u *uploader) upload(ctx context.Context, req UploadRequest, job *rollout.Job, event audit.Event, start time.Time) (string, error) {
+	var url string
+	chart, err := u.chart(ctx, req)
+	if err == nil {
+		url, err = u.rollout(ctx, chart, job, &event)
+	}
+	u.finish(event, start, err)
+	if err != nil {
+		if details := detailsOf(err); details != nil {
+			job.SetDetails(details)
+		}
+		job.Fail(err)
+		return "", err
+	}
+	job.Ready(url)
+	return url, nil
+}
+
+// finish audits the upload and records its metrics, err is nil if it succeeded.
+func (u *uploader) finish(event audit.Event, start time.Time, err error) {
+	if err != nil {
+		event.Fail(err)
+	}
+	u.auditor.Record(event)
+
+	if err != nil {
+		metrics.UploadDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+		metrics.UploadFailures.WithLabelValues(failureReasonOf(err)).Inc()
+	} else {
+		metrics.UploadDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+	}
+}
+
+func (u *uploader) rollout(ctx context.Context, chart helm.Chart, job *rollout.Job, event *audit.Event) (string, error) {
+	k8sNamespace := u.config.K8sNamespace
+	client := u.config.K8SClientset
+	logger := u.logger
+
+	job.SetService(chart.Service().Name)
+	event.Service = chart.Service().Name
+	event.Name = chart.Name()
+	event.ScriptHash = chart.Digest()
+
+	// deploy the chart
+	job.SetPhase(rollout.PhaseCreating)
+	err := chart.Deploy(ctx, client)
+	if err != nil {
+		// TODO: check error status of Teardown
+		newErr := chart.Teardown(ctx, client)
+		if newErr != nil {
+			return "", fmt.Errorf("chart.Deploy(): %w, chart.Teardown(): %w", err, newErr)
+		}
+		return "", fmt.Errorf("chart.Deploy(): %w", err)
+	}
+
+	// wait for deployment to become ready (liveness probe will ensure service is healthy)
+	job.SetPhase(rollout.PhaseWaiting)
+	err = util.WaitForServiceHealth(ctx, client, k8sNamespace, chart.Deployment().Name, logger)
+	if err != nil {
+		logger.Error("Deployment liveness check failed, tearing down", "deployment", chart.Deployment().Name, "error", err)
+		// collect the evidence before teardown destroys it
+		diagnoseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), diagnoseTimeout)
+		details, diagnoseErr := util.DiagnoseDeployment(diagnoseCtx, client, k8sNamespace, chart.Deployment().Name, util.DefaultLogTailLines)
+		cancel()
+		if diagnoseErr != nil {
+			logger.Warn("Failed to diagnose deployment", "deployment", chart.Deployment().Name, "error", diagnoseErr)
+		}
+		// teardown the chart since liveness check failed
+		teardownErr := chart.Teardown(ctx, client)
+		if teardownErr != nil {
+			err = fmt.Errorf("deployment liveness check failed: %w, chart.Teardown() also failed: %w", err, teardownErr)
+		} else {
+			err = fmt.Errorf("deployment liveness check failed: %w", err)
+		}
+		reason := "not_ready"
+		if details != nil {
+			if fatal, isFatal := details.Fatal(); isFatal {
+				reason = fatal
+			}
+		}
+		return "", &uploadError{code: http.StatusInternalServerError, err: err, details: details, reason: reason}
+	}
+
+	// update the reaper
+	u.reaper.MustRegister(ctx, chart.Service().Name, helm.NewChartWrapper(&chart, client))
+
+	route := chart.Service().Name
+	if chart.Name() != "" {
+		route = chart.Name()
+	}
+	ip, err := util.K8sExternalDomainName(ctx, client, u.config.K8sLoadBalancerPort, u.config.GatewayServiceName, u.config.GatewayPathPrefix, k8sNamespace, route)
+	if err != nil {
+		return "", fmt.Errorf("util.K8sExternalDomainName(): %w", err)
+	}
+	return ip, nil
 }
 
-func getUploadHandler(config pkg.Config, reaper *pkg_reaper.Reaper, logger *slog.Logger) http.HandlerFunc {
-	k8sNamespace := config.K8sNamespace
-	client := config.K8SClientset
+// getUploadHandler deploys a function.
+//
+// With `?async=true` it responds 202 right away, and the rollout is polled at /admin/deployments/{id}.
+// Otherwise it blocks until the function is ready.
+func getUploadHandler(config pkg.Config, reaper *pkg_reaper.Reaper, tracker *rollout.Tracker, auditor *audit.Auditor, logger *slog.Logger) http.HandlerFunc {
+	u := &uploader{
+		config:  config,
+		fetcher: source.NewFetcher(config.SourceAllowedHosts, config.SourceFetchTimeout),
+		reaper:  reaper,
+		auditor: auditor,
+		logger:  logger,
+		pending: make(map[string]struct{}),
+	}
 
-	writeErrorResponse := func(w http.ResponseWriter, statusCode int, err error) {
+	writeErrorResponse := func(w http.ResponseWriter, statusCode int, err error, deploymentID string) {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(statusCode)
 		_ = json.NewEncoder(w).Encode(UploadResponse{
-			Code:    statusCode,
-			Message: err.Error(),
+			Code:         statusCode,
+			Message:      err.Error(),
+			DeploymentID: deploymentID,
+			Details:      detailsOf(err),
 		})
 	}
 
@@ -44,59 +373,79 @@ func getUploadHandler(config pkg.Config, reaper *pkg_reaper.Reaper, logger *slog
 		var req UploadRequest
 
 		// validate user request
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("json.NewDecoder().Decode(): %w", err))
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("json.NewDecoder().Decode(): %w", err), "")
 			return
 		}
-
-		// create a helm chart
-		chart, err := helm.NewChart(k8sNamespace, req.Script, req.DotFile)
-		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("helm.NewChart(): %w", err))
-			return
-		}
-
-		// deploy the chart
-		err = chart.Deploy(r.Context(), client)
-		if err != nil {
-			// TODO: check error status of Teardown
-			newErr := chart.Teardown(r.Context(), client)
-			if newErr != nil {
-				writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("chart.Deploy(): %w, chart.Teardown(): %w", err, newErr))
+		async := false
+		if value := r.URL.Query().Get("async"); value != "" {
+			var err error
+			async, err = strconv.ParseBool(value)
+			if err != nil {
+				writeErrorResponse(w, http.StatusBadRequest, fmt.Errorf("strconv.ParseBool(async): %w", err), "")
 				return
 			}
-			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("chart.Deploy(): %w", err))
-			return
 		}
 
-		// wait for deployment to become ready (liveness probe will ensure service is healthy)
-		err = util.WaitForServiceHealth(r.Context(), client, k8sNamespace, chart.Deployment().Name, logger)
+		event := audit.Event{Action: audit.ActionCreate, Name: req.Option.Name}
+		event.FromRequest(r, req.Option.User)
+
+		start := time.Now()
+		job := tracker.New()
+		// the cheap checks run before responding, so that invalid requests and taken names fail even if async,
+		// fetching the code is left to the rollout
+		release, err := u.admit(r.Context(), req)
 		if err != nil {
-			logger.Error("Deployment liveness check failed, tearing down", "deployment", chart.Deployment().Name, "error", err)
-			// teardown the chart since liveness check failed
-			teardownErr := chart.Teardown(r.Context(), client)
-			if teardownErr != nil {
-				writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("deployment liveness check failed: %w, chart.Teardown() also failed: %w", err, teardownErr))
-				return
-			}
-			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("deployment liveness check failed: %w", err))
+			u.finish(event, start, err)
+			job.Fail(err)
+			writeErrorResponse(w, statusCodeOf(err), err, job.ID())
+			return
+		}
+		if async {
+			// the rollout outlives the request
+			ctx := context.WithoutCancel(r.Context())
+			util.SafelyGo(func() {
+				defer release()
+				_, _ = u.upload(ctx, req, job, event, start)
+			}, func(recovered interface{}) {
+				job.Fail(fmt.Errorf("panic: %v", recovered))
+			})
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(UploadResponse{
+				Code:         http.StatusAccepted,
+				Message:      "accepted",
+				DeploymentID: job.ID(),
+			})
 			return
 		}
 
-		// update the reaper
-		reaper.MustRegister(r.Context(), chart.Service().Name, helm.NewChartWrapper(&chart, client))
-
-		ip, err := util.K8sExternalDomainName(r.Context(), client, config.K8sLoadBalancerPort, config.GatewayServiceName, config.GatewayPathPrefix, config.K8sNamespace, chart.Service().Name)
+		defer release()
+		ip, err := u.upload(r.Context(), req, job, event, start)
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, fmt.Errorf("util.K8sExternalDomainName(): %w", err))
+			writeErrorResponse(w, statusCodeOf(err), err, job.ID())
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(UploadResponse{
-			URL:     ip,
-			Code:    http.StatusOK,
-			Message: "success",
+			URL:          ip,
+			Code:         http.StatusOK,
+			Message:      "success",
+			DeploymentID: job.ID(),
 		})
 	}
 	return hanlder
 }
+
+// getDeploymentHandler reports the rollout of an upload.
+func getDeploymentHandler(tracker *rollout.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, exists := tracker.Get(chi.URLParam(r, "id"))
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, errors.New("deployment not found"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(job.Status())
+	}
+}
@@ -0,0 +1,255 @@
+// Package audit records administrative actions on functions, i.e. who deployed what and when.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"poorman-faas/pkg/util"
+	"sync"
+	"time"
+)
+
+// Action is what was done to a function.
+type Action string
+
+const (
+	ActionCreate   Action = "create"
+	ActionUpdate   Action = "update"
+	ActionDelete   Action = "delete"
+	ActionReap     Action = "reap"
+	ActionRollback Action = "rollback"
+	// ActionHydrate is a function discovered from the cluster when the gateway starts.
+	ActionHydrate Action = "hydrate"
+)
+
+// Outcome is whether the action succeeded.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// ActorSystem is the actor of actions taken by the gateway itself, i.e. reaping idle functions.
+const ActorSystem = "system"
+
+// Event is a single administrative action.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Actor is the user who took the action, or [ActorSystem].
+	Actor string `json:"actor"`
+	// APIKey is a fingerprint of the credentials of the request, never the credentials themselves.
+	APIKey     string `json:"api_key,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Action     Action `json:"action"`
+	Service    string `json:"service,omitempty"`
+	Name       string `json:"name,omitempty"`
+	// ScriptHash identifies the deployed code, the bundle digest or the image reference.
+	ScriptHash string  `json:"script_hash,omitempty"`
+	Outcome    Outcome `json:"outcome"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// FromRequest fills the actor of the event from the request.
+//
+// The actor is the declared user, or anonymous. Credentials, if any, are only kept as a fingerprint.
+func (e *Event) FromRequest(r *http.Request, user string) {
+	e.Actor = user
+	if e.Actor == "" {
+		e.Actor = "anonymous"
+	}
+	e.RemoteAddr = util.ClientIP(r)
+	e.APIKey = util.CredentialFingerprint(r)
+}
+
+// Fail marks the event as failed for the given reason.
+func (e *Event) Fail(err error) {
+	e.Outcome = OutcomeFailure
+	e.Reason = err.Error()
+}
+
+// Filter selects events, zero fields match everything.
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	Actor   string
+	Service string
+	Action  Action
+	// Limit returns at most the newest Limit events.
+	Limit int
+}
+
+// Match reports whether the event is selected by the filter.
+func (f Filter) Match(e Event) bool {
+	switch {
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.Service != "" && e.Service != f.Service:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	}
+	return true
+}
+
+// limit keeps the newest events, events MUST be in chronological order.
+func (f Filter) limit(events []Event) []Event {
+	if f.Limit > 0 && len(events) > f.Limit {
+		return events[len(events)-f.Limit:]
+	}
+	return events
+}
+
+// Sink stores events, it MUST be safe for concurrent use.
+type Sink interface {
+	// Write appends an event.
+	Write(e Event) error
+	// Query returns the events matching the filter, in chronological order.
+	Query(f Filter) ([]Event, error)
+}
+
+// Auditor records events to a sink, it is safe to use a nil Auditor.
+type Auditor struct {
+	sink   Sink
+	logger *slog.Logger
+}
+
+// New creates an Auditor, failures to write an event are logged.
+func New(sink Sink, logger *slog.Logger) *Auditor {
+	return &Auditor{sink: sink, logger: logger}
+}
+
+// Record timestamps the event and writes it, the outcome defaults to success.
+func (a *Auditor) Record(e Event) {
+	if a == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Outcome == "" {
+		e.Outcome = OutcomeSuccess
+	}
+	if err := a.sink.Write(e); err != nil {
+		a.logger.Error("Failed to write audit event", "error", err, "action", e.Action, "service", e.Service)
+	}
+}
+
+// Query returns the events matching the filter, in chronological order.
+func (a *Auditor) Query(f Filter) ([]Event, error) {
+	return a.sink.Query(f)
+}
+
+// MemorySink keeps the last events in memory, they are lost on restart.
+type MemorySink struct {
+	size   int
+	mu     sync.RWMutex
+	events []Event
+}
+
+// NewMemorySink creates a MemorySink that keeps the last size events.
+func NewMemorySink(size int) *MemorySink {
+	return &MemorySink{size: max(size, 1)}
+}
+
+// Write implements [Sink].
+func (s *MemorySink) Write(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	if len(s.events) > s.size {
+		s.events = append([]Event(nil), s.events[len(s.events)-s.size:]...)
+	}
+	return nil
+}
+
+// Query implements [Sink].
+func (s *MemorySink) Query(f Filter) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]Event, 0)
+	for _, e := range s.events {
+		if f.Match(e) {
+			events = append(events, e)
+		}
+	}
+	return f.limit(events), nil
+}
+
+// FileSink appends events to a JSONL file, one event per line.
+//
+// Queries scan the whole file, which is fine for the rate of administrative actions.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens the file for appending, creating it if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile(%s): %w", path, err)
+	}
+	return &FileSink{path: path, file: file}, nil
+}
+
+// Write implements [Sink].
+func (s *FileSink) Write(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("file.Write(): %w", err)
+	}
+	return nil
+}
+
+// Query implements [Sink].
+func (s *FileSink) Query(f Filter) ([]Event, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open(%s): %w", s.path, err)
+	}
+	defer file.Close()
+
+	events := make([]Event, 0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var e Event
+			// a partially written last line is skipped
+			if json.Unmarshal(line, &e) == nil && f.Match(e) {
+				events = append(events, e)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reader.ReadBytes(): %w", err)
+		}
+	}
+	return f.limit(events), nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink(): %v", err)
+	}
+	defer sink.Close()
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Time: start, Actor: "alice", Action: ActionCreate, Service: "python-a"},
+		{Time: start.Add(time.Minute), Actor: "bob", Action: ActionCreate, Service: "python-b"},
+		{Time: start.Add(2 * time.Minute), Actor: ActorSystem, Action: ActionReap, Service: "python-a"},
+		{Time: start.Add(3 * time.Minute), Actor: "alice", Action: ActionCreate, Service: "python-c"},
+	}
+	auditor := New(sink, nil)
+	for _, e := range events {
+		auditor.Record(e)
+	}
+	failed := Event{Time: start.Add(4 * time.Minute), Actor: "alice", Action: ActionCreate}
+	failed.Fail(errors.New("not ready"))
+	auditor.Record(failed)
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		services []string
+	}{
+		{"all", Filter{}, []string{"python-a", "python-b", "python-a", "python-c", ""}},
+		{"actor", Filter{Actor: "alice"}, []string{"python-a", "python-c", ""}},
+		{"action", Filter{Action: ActionReap}, []string{"python-a"}},
+		{"window", Filter{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)}, []string{"python-b", "python-a"}},
+		{"limit keeps the newest", Filter{Actor: "alice", Limit: 2}, []string{"python-c", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auditor.Query(tt.filter)
+			if err != nil {
+				t.Fatalf("Query(): %v", err)
+			}
+			if len(got) != len(tt.services) {
+				t.Fatalf("Expected %d events, got %d: %+v", len(tt.services), len(got), got)
+			}
+			for i, e := range got {
+				if e.Service != tt.services[i] {
+					t.Errorf("Expected event %d of service %q, got %q", i, tt.services[i], e.Service)
+				}
+			}
+		})
+	}
+
+	got, _ := auditor.Query(Filter{Since: start.Add(4 * time.Minute)})
+	if len(got) != 1 || got[0].Outcome != OutcomeFailure || got[0].Reason != "not ready" {
+		t.Errorf("Expected a failure with its reason, got %+v", got)
+	}
+	got, _ = auditor.Query(Filter{Action: ActionReap})
+	if got[0].Outcome != OutcomeSuccess {
+		t.Errorf("Expected the default outcome %q, got %q", OutcomeSuccess, got[0].Outcome)
+	}
+}
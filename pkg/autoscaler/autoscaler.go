@@ -0,0 +1,305 @@
+// Package autoscaler scales the replicas of functions with the requests they serve, like the Knative Pod Autoscaler.
+//
+// The concurrency of each function, its in-flight and queued requests, is sampled every tick.
+// Replicas follow the average over the stable window, unless the average over the shorter panic window
+// calls for at least PanicThreshold times the current replicas. Then the autoscaler panics:
+// it follows the panic window and never scales down, until the load stays below the threshold for a stable window.
+package autoscaler
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// Bounds is how a function is autoscaled.
+type Bounds struct {
+	Min int32
+	Max int32
+	// TargetConcurrency is how many concurrent requests each replica should serve.
+	TargetConcurrency float64
+}
+
+// Scaler reads and sets the replicas of a function, i.e. of its Deployment.
+type Scaler interface {
+	Replicas(ctx context.Context, service string) (int32, error)
+	Scale(ctx context.Context, service string, replicas int32) error
+}
+
+// Decision is a change of the replicas of a function.
+type Decision struct {
+	Time              time.Time `json:"time"`
+	From              int32     `json:"from"`
+	To                int32     `json:"to"`
+	StableConcurrency float64   `json:"stable_concurrency"`
+	PanicConcurrency  float64   `json:"panic_concurrency"`
+	Panic             bool      `json:"panic"`
+	// Error is why the replicas could not be changed, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Status is the state of the autoscaler for a function.
+type Status struct {
+	Service           string     `json:"service"`
+	Min               int32      `json:"min"`
+	Max               int32      `json:"max"`
+	TargetConcurrency float64    `json:"target_concurrency"`
+	Replicas          int32      `json:"replicas"`
+	StableConcurrency float64    `json:"stable_concurrency"`
+	PanicConcurrency  float64    `json:"panic_concurrency"`
+	Panic             bool       `json:"panic"`
+	Decisions         []Decision `json:"decisions"`
+}
+
+type sample struct {
+	time  time.Time
+	value float64
+}
+
+// state is what the autoscaler knows about a function.
+type state struct {
+	bounds     Bounds
+	samples    []sample
+	replicas   int32
+	stable     float64
+	panic      float64
+	panicUntil time.Time
+	panicking  bool
+	// newest last, up to maxDecisions
+	decisions []Decision
+}
+
+// Autoscaler scales the functions returned by targets, with the concurrency returned by load.
+type Autoscaler struct {
+	scaler  Scaler
+	load    func(service string) float64
+	targets func() map[string]Bounds
+	logger  *slog.Logger
+
+	tick           time.Duration
+	stableWindow   time.Duration
+	panicWindow    time.Duration
+	panicThreshold float64
+	maxDecisions   int
+
+	mu     sync.Mutex
+	states map[string]*state
+}
+
+type Option func(a *Autoscaler)
+
+// WithTick sets how often the concurrency is sampled, defaults to 2s.
+func WithTick(d time.Duration) Option {
+	return func(a *Autoscaler) {
+		a.tick = d
+	}
+}
+
+// WithStableWindow sets the window replicas follow, defaults to 60s.
+func WithStableWindow(d time.Duration) Option {
+	return func(a *Autoscaler) {
+		a.stableWindow = d
+	}
+}
+
+// WithPanicWindow sets the window that detects bursts, defaults to 6s.
+func WithPanicWindow(d time.Duration) Option {
+	return func(a *Autoscaler) {
+		a.panicWindow = d
+	}
+}
+
+// WithPanicThreshold sets how many times the current replicas the panic window must call for to panic, defaults to 2.
+func WithPanicThreshold(threshold float64) Option {
+	return func(a *Autoscaler) {
+		a.panicThreshold = threshold
+	}
+}
+
+// New creates an Autoscaler, see [Autoscaler.Run].
+func New(scaler Scaler, load func(service string) float64, targets func() map[string]Bounds, logger *slog.Logger, opts ...Option) *Autoscaler {
+	a := &Autoscaler{
+		scaler:         scaler,
+		load:           load,
+		targets:        targets,
+		logger:         logger,
+		tick:           2 * time.Second,
+		stableWindow:   60 * time.Second,
+		panicWindow:    6 * time.Second,
+		panicThreshold: 2,
+		maxDecisions:   50,
+		states:         make(map[string]*state),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Run samples and scales the functions every tick, until the context is done.
+func (a *Autoscaler) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.Tick(ctx, now)
+		}
+	}
+}
+
+// Tick samples and scales the functions once.
+func (a *Autoscaler) Tick(ctx context.Context, now time.Time) {
+	targets := a.targets()
+	a.mu.Lock()
+	for service := range a.states {
+		if _, exists := targets[service]; !exists {
+			delete(a.states, service)
+		}
+	}
+	a.mu.Unlock()
+
+	for service, bounds := range targets {
+		a.scale(ctx, service, bounds, now)
+	}
+}
+
+// scale decides the replicas of a function, and applies them if they changed.
+func (a *Autoscaler) scale(ctx context.Context, service string, bounds Bounds, now time.Time) {
+	a.mu.Lock()
+	s, exists := a.states[service]
+	a.mu.Unlock()
+	if !exists {
+		replicas, err := a.scaler.Replicas(ctx, service)
+		if err != nil {
+			a.logger.Error("scaler.Replicas()", "error", err, "service", service)
+			return
+		}
+		s = &state{replicas: replicas}
+		a.mu.Lock()
+		a.states[service] = s
+		a.mu.Unlock()
+	}
+
+	a.mu.Lock()
+	s.bounds = bounds
+	s.samples = append(s.samples, sample{time: now, value: a.load(service)})
+	s.stable = s.average(now.Add(-a.stableWindow))
+	s.panic = s.average(now.Add(-a.panicWindow))
+	decision := Decision{
+		Time:              now,
+		From:              s.replicas,
+		To:                a.desired(s, now),
+		StableConcurrency: s.stable,
+		PanicConcurrency:  s.panic,
+		Panic:             now.Before(s.panicUntil),
+	}
+	s.panicking = decision.Panic
+	a.mu.Unlock()
+	if decision.To == decision.From {
+		return
+	}
+
+	if err := a.scaler.Scale(ctx, service, decision.To); err != nil {
+		decision.Error = err.Error()
+		a.logger.Error("scaler.Scale()", "error", err, "service", service, "from", decision.From, "to", decision.To)
+	} else {
+		a.logger.Info("Autoscaler scaled", "service", service, "from", decision.From, "to", decision.To,
+			"stable_concurrency", decision.StableConcurrency, "panic_concurrency", decision.PanicConcurrency, "panic", decision.Panic)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if decision.Error == "" {
+		s.replicas = decision.To
+	}
+	s.decisions = append(s.decisions, decision)
+	if len(s.decisions) > a.maxDecisions {
+		s.decisions = s.decisions[len(s.decisions)-a.maxDecisions:]
+	}
+}
+
+// average averages the samples since the given time. It MUST be called with the lock held.
+func (s *state) average(since time.Time) float64 {
+	var sum float64
+	var count int
+	for _, sample := range s.samples {
+		if sample.time.After(since) {
+			sum += sample.value
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// desired returns the replicas the function needs, within its bounds. It MUST be called with the lock held.
+func (a *Autoscaler) desired(s *state, now time.Time) int32 {
+	// only the stable window is needed from now on
+	oldest := now.Add(-a.stableWindow)
+	for len(s.samples) > 0 && s.samples[0].time.Before(oldest) {
+		s.samples = s.samples[1:]
+	}
+
+	target := s.bounds.TargetConcurrency
+	if target <= 0 {
+		target = 1
+	}
+	stable := int32(math.Ceil(s.stable / target))
+	burst := int32(math.Ceil(s.panic / target))
+
+	current := max(s.replicas, 1)
+	if float64(burst)/float64(current) >= a.panicThreshold {
+		s.panicUntil = now.Add(a.stableWindow)
+	}
+	desired := stable
+	if now.Before(s.panicUntil) {
+		// never scale down while panicking
+		desired = max(burst, s.replicas)
+	}
+	return min(max(desired, s.bounds.Min, 1), max(s.bounds.Max, 1))
+}
+
+// Status returns the state of the autoscaler for the function, or false if it is not autoscaled.
+func (a *Autoscaler) Status(service string) (Status, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, exists := a.states[service]
+	if !exists {
+		return Status{}, false
+	}
+	return Status{
+		Service:           service,
+		Min:               s.bounds.Min,
+		Max:               s.bounds.Max,
+		TargetConcurrency: s.bounds.TargetConcurrency,
+		Replicas:          s.replicas,
+		StableConcurrency: s.stable,
+		PanicConcurrency:  s.panic,
+		Panic:             s.panicking,
+		Decisions:         append([]Decision{}, s.decisions...),
+	}, true
+}
+
+// CurrentReplicas returns the replicas of the function as last scaled, or 0 if it is not autoscaled.
+func (a *Autoscaler) CurrentReplicas(service string) int32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s, exists := a.states[service]; exists {
+		return s.replicas
+	}
+	return 0
+}
+
+// Forget drops the state of the function, i.e. once it is culled.
+func (a *Autoscaler) Forget(service string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.states, service)
+}
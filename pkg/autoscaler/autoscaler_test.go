@@ -0,0 +1,85 @@
+package autoscaler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type fakeScaler struct {
+	replicas int32
+}
+
+func (f *fakeScaler) Replicas(ctx context.Context, service string) (int32, error) {
+	return f.replicas, nil
+}
+
+func (f *fakeScaler) Scale(ctx context.Context, service string, replicas int32) error {
+	f.replicas = replicas
+	return nil
+}
+
+func TestAutoscaler(t *testing.T) {
+	ctx := context.Background()
+	scaler := &fakeScaler{replicas: 1}
+	load := 0.0
+	a := New(scaler,
+		func(string) float64 { return load },
+		func() map[string]Bounds {
+			return map[string]Bounds{"service-1": {Min: 1, Max: 5, TargetConcurrency: 1}}
+		},
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		WithStableWindow(10*time.Second),
+		WithPanicWindow(2*time.Second),
+	)
+	now := time.Now()
+	tick := func(seconds int) {
+		for range seconds {
+			now = now.Add(time.Second)
+			a.Tick(ctx, now)
+		}
+	}
+
+	tick(10)
+	if scaler.replicas != 1 {
+		t.Fatalf("Expected the minimum without load, got %d", scaler.replicas)
+	}
+
+	// a burst panics, and scales up right away
+	load = 4
+	tick(1)
+	if status, _ := a.Status("service-1"); scaler.replicas != 2 || !status.Panic {
+		t.Errorf("Expected to panic to 2 replicas, got %d %+v", scaler.replicas, status)
+	}
+	tick(1)
+	if scaler.replicas != 4 {
+		t.Errorf("Expected to follow the panic window to 4 replicas, got %d", scaler.replicas)
+	}
+	load = 20
+	tick(2)
+	if scaler.replicas != 5 {
+		t.Errorf("Expected to be capped at 5 replicas, got %d", scaler.replicas)
+	}
+
+	// no scale down while panicking
+	load = 0
+	tick(5)
+	if scaler.replicas != 5 {
+		t.Errorf("Expected no scale down while panicking, got %d", scaler.replicas)
+	}
+	tick(10)
+	status, _ := a.Status("service-1")
+	if scaler.replicas != 1 || status.Panic {
+		t.Errorf("Expected to scale down to the minimum once stable, got %d %+v", scaler.replicas, status)
+	}
+	if len(status.Decisions) == 0 || status.Decisions[0].From != 1 || status.Decisions[len(status.Decisions)-1].To != 1 {
+		t.Errorf("Expected the decisions to be kept, got %+v", status.Decisions)
+	}
+
+	a.Forget("service-1")
+	if _, exists := a.Status("service-1"); exists {
+		t.Error("Expected no status once forgotten")
+	}
+}
@@ -0,0 +1,51 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DeploymentScaler scales the Deployment of a function through its scale subresource.
+type DeploymentScaler struct {
+	clientset kubernetes.Interface
+	namespace string
+	// deploymentOf returns the Deployment of a service
+	deploymentOf func(service string) string
+}
+
+// NewDeploymentScaler creates a DeploymentScaler, deploymentOf returns the Deployment running a service.
+func NewDeploymentScaler(clientset kubernetes.Interface, namespace string, deploymentOf func(service string) string) *DeploymentScaler {
+	return &DeploymentScaler{
+		clientset:    clientset,
+		namespace:    namespace,
+		deploymentOf: deploymentOf,
+	}
+}
+
+// Replicas implements [Scaler].
+func (d *DeploymentScaler) Replicas(ctx context.Context, service string) (int32, error) {
+	name := d.deploymentOf(service)
+	scale, err := d.clientset.AppsV1().Deployments(d.namespace).GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("clientset.AppsV1().Deployments().GetScale(%s): %w", name, err)
+	}
+	return scale.Spec.Replicas, nil
+}
+
+// Scale implements [Scaler].
+func (d *DeploymentScaler) Scale(ctx context.Context, service string, replicas int32) error {
+	name := d.deploymentOf(service)
+	deployments := d.clientset.AppsV1().Deployments(d.namespace)
+	scale, err := deployments.GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("clientset.AppsV1().Deployments().GetScale(%s): %w", name, err)
+	}
+	scale.Spec.Replicas = replicas
+	if _, err := deployments.UpdateScale(ctx, name, scale, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("clientset.AppsV1().Deployments().UpdateScale(%s): %w", name, err)
+	}
+	return nil
+}
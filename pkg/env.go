@@ -3,6 +3,9 @@ package pkg
 
 import (
 	"fmt"
+	"log/slog"
+	"poorman-faas/pkg/policy"
+	"poorman-faas/pkg/proxy"
 	"strings"
 	"time"
 
@@ -12,8 +15,18 @@ import (
 )
 
 type Config struct {
-	// for logging
+	// for logging, one of debug, info, warn, error
 	LogLevel string `env:"LOG_LEVEL" envDefault:"debug"`
+	// for the audit log, kept in memory if the path is empty
+	AuditLogPath string `env:"AUDIT_LOG_PATH"`
+	// for the invocation history of each function, bodies are redacted with ACCESS_LOG_REDACT_FIELDS
+	InvocationHistorySize  int `env:"INVOCATION_HISTORY_SIZE" envDefault:"100"`
+	InvocationPayloadBytes int `env:"INVOCATION_PAYLOAD_BYTES" envDefault:"1024"`
+	// for the access log of invocations, bodies are not captured if 0
+	AccessLogBodyBytes     int      `env:"ACCESS_LOG_BODY_BYTES" envDefault:"0"`
+	AccessLogHeaders       bool     `env:"ACCESS_LOG_HEADERS" envDefault:"false"`
+	AccessLogRedactHeaders []string `env:"ACCESS_LOG_REDACT_HEADERS" envDefault:"Authorization,Proxy-Authorization,Cookie,Set-Cookie,X-Api-Key"`
+	AccessLogRedactFields  []string `env:"ACCESS_LOG_REDACT_FIELDS" envDefault:"password,token,access_token,refresh_token,secret,api_key"`
 	// for Reaper
 	ReaperPollEvery  time.Duration `env:"REAPER_POLL_EVERY" envDefault:"10s"`
 	ReaperTimeToLive time.Duration `env:"REAPER_TIME_TO_LIVE" envDefault:"30s"`
@@ -25,6 +38,67 @@ type Config struct {
 	Port               int    `env:"PORT" envDefault:"8080"`
 	GatewayServiceName string `env:"GATEWAY_SERVICE_NAME" envDefault:"faas-gateway"`
 	GatewayPathPrefix  string `env:"GATEWAY_PATH_PREFIX" envDefault:"/gateway"`
+	// clients retry functions that are starting after this delay
+	GatewayRetryAfter time.Duration `env:"GATEWAY_RETRY_AFTER" envDefault:"5s"`
+	// default rate limits of the gateway, as `{requests}/{window}`, unlimited if empty
+	// functions can declare their own function and caller limits at upload, callers are keyed by client IP
+	RateLimitFunction policy.Rate `env:"RATE_LIMIT_FUNCTION"`
+	RateLimitCaller   policy.Rate `env:"RATE_LIMIT_CALLER"`
+	RateLimitOwner    policy.Rate `env:"RATE_LIMIT_OWNER"`
+	// default concurrency of functions, unlimited if 0, functions can declare their own at upload
+	ConcurrencyMaxInFlight  int           `env:"CONCURRENCY_MAX_IN_FLIGHT" envDefault:"0"`
+	ConcurrencyQueueSize    int           `env:"CONCURRENCY_QUEUE_SIZE" envDefault:"100"`
+	ConcurrencyQueueTimeout time.Duration `env:"CONCURRENCY_QUEUE_TIMEOUT" envDefault:"30s"`
+	// for the autoscaler of functions that declare max_replicas, see pkg/autoscaler
+	AutoscalerTick              time.Duration `env:"AUTOSCALER_TICK" envDefault:"2s"`
+	AutoscalerStableWindow      time.Duration `env:"AUTOSCALER_STABLE_WINDOW" envDefault:"60s"`
+	AutoscalerPanicWindow       time.Duration `env:"AUTOSCALER_PANIC_WINDOW" envDefault:"6s"`
+	AutoscalerPanicThreshold    float64       `env:"AUTOSCALER_PANIC_THRESHOLD" envDefault:"2"`
+	AutoscalerTargetConcurrency float64       `env:"AUTOSCALER_TARGET_CONCURRENCY" envDefault:"1"`
+	AutoscalerMaxReplicas       int           `env:"AUTOSCALER_MAX_REPLICAS" envDefault:"10"`
+	// for requests to functions, functions can declare their own at upload, up to the caps
+	RequestTimeout         time.Duration `env:"REQUEST_TIMEOUT" envDefault:"60s"`
+	RequestTimeoutCap      time.Duration `env:"REQUEST_TIMEOUT_CAP" envDefault:"15m"`
+	RequestMaxBodyBytes    int64         `env:"REQUEST_MAX_BODY_BYTES" envDefault:"10485760"`
+	RequestMaxBodyBytesCap int64         `env:"REQUEST_MAX_BODY_BYTES_CAP" envDefault:"104857600"`
+	// for spreading requests over the pods of functions, through kube-proxy if empty
+	// functions can declare their own strategy and hash header at upload
+	LoadBalancing           string `env:"LOAD_BALANCING" envDefault:"round-robin"`
+	LoadBalancingHashHeader string `env:"LOAD_BALANCING_HASH_HEADER"`
+	// for canary revisions of a name, rolled back once their error rate exceeds this, disabled if 0
+	CanaryRollbackErrorRate   float64       `env:"CANARY_ROLLBACK_ERROR_RATE" envDefault:"0.1"`
+	CanaryRollbackMinRequests int           `env:"CANARY_ROLLBACK_MIN_REQUESTS" envDefault:"20"`
+	CanaryRollbackWindow      time.Duration `env:"CANARY_ROLLBACK_WINDOW" envDefault:"1m"`
+	// weight of the canaries that declare none, against 100 for the stable revision
+	CanaryWeight int `env:"CANARY_WEIGHT" envDefault:"5"`
+	// for requests that cannot reach a function, functions can declare their own retries at upload
+	Retries                int           `env:"RETRIES" envDefault:"0"`
+	RetryBackoff           time.Duration `env:"RETRY_BACKOFF" envDefault:"100ms"`
+	RetryMaxBodyBytes      int64         `env:"RETRY_MAX_BODY_BYTES" envDefault:"1048576"`
+	CircuitBreakerFailures int           `env:"CIRCUIT_BREAKER_FAILURES" envDefault:"5"`
+	CircuitBreakerCooldown time.Duration `env:"CIRCUIT_BREAKER_COOLDOWN" envDefault:"10s"`
+	// for functions that declare mcp, idle sessions are forgotten after this
+	MCPSessionTTL time.Duration `env:"MCP_SESSION_TTL" envDefault:"1h"`
+	// for /mcp/{user}, the path that MCP functions serve on
+	MCPPath string `env:"MCP_PATH" envDefault:"/mcp"`
+	// for fetching code from remote sources
+	SourceAllowedHosts []string      `env:"SOURCE_ALLOWED_HOSTS" envDefault:"github.com,raw.githubusercontent.com,api.github.com,gist.githubusercontent.com"`
+	SourceFetchTimeout time.Duration `env:"SOURCE_FETCH_TIMEOUT" envDefault:"30s"`
+	// for prebuilt image functions, no image is allowed if empty
+	ImageAllowedRegistries []string `env:"IMAGE_ALLOWED_REGISTRIES"`
+	// for tracing, spans are not exported if the endpoint is empty
+	OtelTracesEndpoint string  `env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	OtelServiceName    string  `env:"OTEL_SERVICE_NAME" envDefault:"faas-gateway"`
+	OtelSampleRatio    float64 `env:"OTEL_TRACES_SAMPLER_ARG" envDefault:"1"`
+}
+
+// SlogLevel parses LogLevel.
+func (cfg Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return level, fmt.Errorf("cfg.LogLevel: %w", err)
+	}
+	return level, nil
 }
 
 // GetConfig parses the environment variables and returns a Config.
@@ -55,6 +129,47 @@ func GetConfig() (Config, error) {
 		return cfg, fmt.Errorf("cfg.GatewayPathPrefix MUST NOT end with /")
 	}
 
+	if !strings.HasPrefix(cfg.MCPPath, "/") {
+		return cfg, fmt.Errorf("cfg.MCPPath MUST start with /")
+	}
+
+	if _, err := cfg.SlogLevel(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.InvocationHistorySize <= 0 {
+		return cfg, fmt.Errorf("cfg.InvocationHistorySize must be greater than 0")
+	}
+
+	if cfg.InvocationPayloadBytes < 0 {
+		return cfg, fmt.Errorf("cfg.InvocationPayloadBytes MUST NOT be negative")
+	}
+
+	if cfg.AccessLogBodyBytes < 0 {
+		return cfg, fmt.Errorf("cfg.AccessLogBodyBytes MUST NOT be negative")
+	}
+
+	if cfg.RequestTimeoutCap <= 0 || cfg.RequestTimeout > cfg.RequestTimeoutCap {
+		return cfg, fmt.Errorf("cfg.RequestTimeout MUST NOT exceed cfg.RequestTimeoutCap")
+	}
+
+	if cfg.RequestMaxBodyBytesCap <= 0 || cfg.RequestMaxBodyBytes > cfg.RequestMaxBodyBytesCap {
+		return cfg, fmt.Errorf("cfg.RequestMaxBodyBytes MUST NOT exceed cfg.RequestMaxBodyBytesCap")
+	}
+
+	if cfg.LoadBalancing != "" {
+		if _, err := proxy.ParseStrategy(cfg.LoadBalancing); err != nil {
+			return cfg, fmt.Errorf("cfg.LoadBalancing: %w", err)
+		}
+	}
+
+	if cfg.CanaryRollbackErrorRate < 0 || cfg.CanaryRollbackErrorRate >= 1 {
+		return cfg, fmt.Errorf("cfg.CanaryRollbackErrorRate MUST be in [0, 1)")
+	}
+	if cfg.CanaryWeight < 0 {
+		return cfg, fmt.Errorf("cfg.CanaryWeight MUST NOT be negative")
+	}
+
 	if cfg.Port <= 0 {
 		return cfg, fmt.Errorf("cfg.Port must be greater than 0")
 	}
@@ -0,0 +1,299 @@
+package helm
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// DefaultEntrypoint is the file that gets run when none is declared.
+	DefaultEntrypoint = "main.py"
+	// MaxBundleSize caps the total uncompressed size of a bundle.
+	// A ConfigMap cannot exceed 1MiB, so we leave some room for metadata.
+	MaxBundleSize = 768 * 1024
+	// MaxBundleFiles caps the number of files in a bundle.
+	MaxBundleFiles = 128
+)
+
+// Bundle is the set of files that make up a Python Faas.
+//
+// Files are keyed by their slash separated path relative to the bundle root,
+// and the Entrypoint is the one that gets run with `uv run --script`.
+type Bundle struct {
+	Entrypoint string
+	Files      map[string][]byte
+}
+
+// NewScriptBundle creates a single file Bundle from a base64 encoded Python script.
+func NewScriptBundle(scriptBase64 string) (Bundle, error) {
+	scriptBytes, err := base64.StdEncoding.DecodeString(scriptBase64)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("base64.DecodeString(script): %w", err)
+	}
+	if len(scriptBytes) > MaxBundleSize {
+		return Bundle{}, fmt.Errorf("script exceeds %d bytes", MaxBundleSize)
+	}
+	return Bundle{
+		Entrypoint: DefaultEntrypoint,
+		Files:      map[string][]byte{DefaultEntrypoint: scriptBytes},
+	}, nil
+}
+
+// NewArchiveBundle creates a Bundle from a base64 encoded zip or tar.gz archive.
+//
+// See [ReadArchiveBundle] for the accepted archives.
+func NewArchiveBundle(archiveBase64 string, entrypoint string) (Bundle, error) {
+	archive, err := base64.StdEncoding.DecodeString(archiveBase64)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("base64.DecodeString(bundle): %w", err)
+	}
+	return ReadArchiveBundle(archive, entrypoint)
+}
+
+// IsArchive reports whether content looks like a zip or tar.gz archive.
+func IsArchive(content []byte) bool {
+	return bytes.HasPrefix(content, gzipMagic) || bytes.HasPrefix(content, zipMagic)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// ReadArchiveBundle creates a Bundle from a zip or tar.gz archive.
+//
+// The archive format is sniffed from its content. Only regular files are accepted,
+// their paths MUST be relative and MUST NOT escape the archive root.
+// If entrypoint is empty, [DefaultEntrypoint] is used.
+func ReadArchiveBundle(archive []byte, entrypoint string) (Bundle, error) {
+	if len(archive) > MaxBundleSize {
+		return Bundle{}, fmt.Errorf("bundle exceeds %d bytes", MaxBundleSize)
+	}
+
+	var files map[string][]byte
+	var err error
+	switch {
+	case bytes.HasPrefix(archive, gzipMagic):
+		files, err = readTarGz(archive)
+	case bytes.HasPrefix(archive, zipMagic):
+		files, err = readZip(archive)
+	default:
+		return Bundle{}, fmt.Errorf("bundle is neither a zip nor a tar.gz archive")
+	}
+	if err != nil {
+		return Bundle{}, err
+	}
+	return newBundle(files, entrypoint)
+}
+
+// NewBundle creates a Bundle from files keyed by their slash separated path.
+//
+// It enforces the same path and size checks as archives.
+// If entrypoint is empty, [DefaultEntrypoint] is used.
+func NewBundle(files map[string][]byte, entrypoint string) (Bundle, error) {
+	bw := bundleWriter{files: make(map[string][]byte)}
+	// sort so that limit errors are deterministic
+	for _, p := range (Bundle{Files: files}).Paths() {
+		if err := bw.add(p, bytes.NewReader(files[p])); err != nil {
+			return Bundle{}, err
+		}
+	}
+	return newBundle(bw.files, entrypoint)
+}
+
+func newBundle(files map[string][]byte, entrypoint string) (Bundle, error) {
+	if entrypoint == "" {
+		entrypoint = DefaultEntrypoint
+	}
+	entrypoint, err := cleanBundlePath(entrypoint)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("entrypoint: %w", err)
+	}
+	if _, exists := files[entrypoint]; !exists {
+		return Bundle{}, fmt.Errorf("entrypoint %q not found in bundle", entrypoint)
+	}
+
+	return Bundle{
+		Entrypoint: entrypoint,
+		Files:      files,
+	}, nil
+}
+
+// Validate checks the entrypoint is PEP 723 compliant, other files are not inspected.
+func (b Bundle) Validate() error {
+	script, exists := b.Files[b.Entrypoint]
+	if !exists {
+		return fmt.Errorf("entrypoint %q not found in bundle", b.Entrypoint)
+	}
+
+	schema, err := NewMetadata(string(script))
+	if err != nil {
+		return fmt.Errorf("NewMetadata(): %w", err)
+	}
+
+	if !schema.Validate() {
+		return fmt.Errorf("script is not PEP 723 compliant")
+	}
+	return nil
+}
+
+// Paths returns the file paths of the bundle in sorted order.
+func (b Bundle) Paths() []string {
+	paths := make([]string, 0, len(b.Files))
+	for p := range b.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Digest returns a sha256 of the entrypoint and the files, independent of how the bundle was uploaded.
+func (b Bundle) Digest() string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "entrypoint:%s\n", b.Entrypoint)
+	for _, p := range b.Paths() {
+		_, _ = fmt.Fprintf(h, "file:%s:%d\n", p, len(b.Files[p]))
+		_, _ = h.Write(b.Files[p])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// configMapKeys maps each file path to a ConfigMap key.
+//
+// ConfigMap keys cannot contain slashes, so nested paths are flattened with dots,
+// and the volume projects each key back to its path.
+func (b Bundle) configMapKeys() (map[string]string, error) {
+	keys := make(map[string]string, len(b.Files))
+	seen := make(map[string]string, len(b.Files))
+	for _, p := range b.Paths() {
+		key := strings.ReplaceAll(p, "/", ".")
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			return nil, fmt.Errorf("path %q cannot be stored: %s", p, strings.Join(errs, ", "))
+		}
+		if other, exists := seen[key]; exists {
+			return nil, fmt.Errorf("paths %q and %q collide", other, p)
+		}
+		seen[key] = p
+		keys[p] = key
+	}
+	return keys, nil
+}
+
+// cleanBundlePath normalizes a path inside an archive and rejects those escaping its root.
+func cleanBundlePath(name string) (string, error) {
+	if strings.Contains(name, "\\") {
+		return "", fmt.Errorf("path %q MUST use forward slashes", name)
+	}
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("path %q MUST be relative", name)
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("path %q escapes the bundle root", name)
+	}
+	return cleaned, nil
+}
+
+// bundleWriter collects files while enforcing the bundle limits.
+type bundleWriter struct {
+	files map[string][]byte
+	total int64
+}
+
+func (bw *bundleWriter) add(name string, r io.Reader) error {
+	cleaned, err := cleanBundlePath(name)
+	if err != nil {
+		return err
+	}
+	if _, exists := bw.files[cleaned]; exists {
+		return fmt.Errorf("path %q appears more than once", name)
+	}
+	if len(bw.files) >= MaxBundleFiles {
+		return fmt.Errorf("bundle has more than %d files", MaxBundleFiles)
+	}
+
+	// read one byte past the budget to detect oversized (or lying) entries
+	remaining := MaxBundleSize - bw.total
+	content, err := io.ReadAll(io.LimitReader(r, remaining+1))
+	if err != nil {
+		return fmt.Errorf("read %q: %w", name, err)
+	}
+	if int64(len(content)) > remaining {
+		return fmt.Errorf("bundle exceeds %d bytes when uncompressed", MaxBundleSize)
+	}
+	bw.total += int64(len(content))
+	bw.files[cleaned] = content
+	return nil
+}
+
+func readTarGz(archive []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("gzip.NewReader(): %w", err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	bw := bundleWriter{files: make(map[string][]byte)}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar.Next(): %w", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+			if err := bw.add(header.Name, tr); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("path %q is not a regular file", header.Name)
+		}
+	}
+	return bw.files, nil
+}
+
+func readZip(archive []byte) (map[string][]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("zip.NewReader(): %w", err)
+	}
+
+	bw := bundleWriter{files: make(map[string][]byte)}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if !mode.IsRegular() {
+			return nil, fmt.Errorf("path %q is not a regular file", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("zip.Open(%q): %w", f.Name, err)
+		}
+		err = bw.add(f.Name, rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bw.files, nil
+}
@@ -0,0 +1,168 @@
+package helm
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const testEntrypoint = `# /// script
+# requires-python = ">=3.12"
+# dependencies = []
+# ///
+
+import helper
+`
+
+func tarGzBase64(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatalf("tw.WriteHeader(): %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("tw.Write(): %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tw.Close(): %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gz.Close(): %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func zipBase64(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zw.Create(): %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("w.Write(): %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zw.Close(): %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestNewArchiveBundle(t *testing.T) {
+	files := map[string]string{
+		"app/main.py":        testEntrypoint,
+		"app/helper.py":      "VALUE = 1\n",
+		"app/prompts/hi.txt": "hello\n",
+	}
+
+	t.Run("tar.gz", func(t *testing.T) {
+		bundle, err := NewArchiveBundle(tarGzBase64(t, files), "app/main.py")
+		if err != nil {
+			t.Fatalf("NewArchiveBundle(): %v", err)
+		}
+		if len(bundle.Files) != 3 {
+			t.Errorf("Expected 3 files, got %d", len(bundle.Files))
+		}
+		if err := bundle.Validate(); err != nil {
+			t.Errorf("Validate(): %v", err)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		bundle, err := NewArchiveBundle(zipBase64(t, files), "./app/main.py")
+		if err != nil {
+			t.Fatalf("NewArchiveBundle(): %v", err)
+		}
+		if bundle.Entrypoint != "app/main.py" {
+			t.Errorf("Expected cleaned entrypoint, got %s", bundle.Entrypoint)
+		}
+	})
+
+	t.Run("path traversal is rejected", func(t *testing.T) {
+		evil := map[string]string{"main.py": testEntrypoint, "../evil.py": ""}
+		_, err := NewArchiveBundle(tarGzBase64(t, evil), "")
+		if err == nil || !strings.Contains(err.Error(), "escapes") {
+			t.Errorf("Expected path traversal error, got %v", err)
+		}
+	})
+
+	t.Run("absolute path is rejected", func(t *testing.T) {
+		evil := map[string]string{"main.py": testEntrypoint, "/etc/passwd": ""}
+		if _, err := NewArchiveBundle(zipBase64(t, evil), ""); err == nil {
+			t.Error("Expected absolute path error")
+		}
+	})
+
+	t.Run("missing entrypoint", func(t *testing.T) {
+		if _, err := NewArchiveBundle(zipBase64(t, files), "main.py"); err == nil {
+			t.Error("Expected missing entrypoint error")
+		}
+	})
+
+	t.Run("oversized bundle", func(t *testing.T) {
+		big := map[string]string{"main.py": strings.Repeat("#", MaxBundleSize+1)}
+		if _, err := NewArchiveBundle(tarGzBase64(t, big), ""); err == nil {
+			t.Error("Expected size limit error")
+		}
+	})
+
+	t.Run("only entrypoint must be PEP 723 compliant", func(t *testing.T) {
+		bundle, err := NewArchiveBundle(zipBase64(t, files), "app/helper.py")
+		if err != nil {
+			t.Fatalf("NewArchiveBundle(): %v", err)
+		}
+		if err := bundle.Validate(); err == nil {
+			t.Error("Expected helper.py to fail PEP 723 validation")
+		}
+	})
+}
+
+func TestChartProjectsBundle(t *testing.T) {
+	bundle, err := NewArchiveBundle(zipBase64(t, map[string]string{
+		"main.py":         testEntrypoint,
+		"pkg/__init__.py": "",
+		"data.bin":        "\xff\xfe",
+	}), "")
+	if err != nil {
+		t.Fatalf("NewArchiveBundle(): %v", err)
+	}
+	chart, err := NewChart("faas", bundle, "")
+	if err != nil {
+		t.Fatalf("NewChart(): %v", err)
+	}
+
+	cm := chart.ConfigMap()
+	if _, exists := cm.Data["pkg.__init__.py"]; !exists {
+		t.Errorf("Expected nested file to be flattened, got keys %v", cm.Data)
+	}
+	if _, exists := cm.BinaryData["data.bin"]; !exists {
+		t.Error("Expected binary file in BinaryData")
+	}
+
+	items := chart.Deployment().Spec.Template.Spec.Volumes[0].ConfigMap.Items
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 projected items, got %d", len(items))
+	}
+	for _, item := range items {
+		if item.Key == "pkg.__init__.py" && item.Path != "pkg/__init__.py" {
+			t.Errorf("Expected pkg/__init__.py, got %s", item.Path)
+		}
+	}
+}
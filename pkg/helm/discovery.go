@@ -100,8 +100,9 @@ func DiscoverCharts(ctx context.Context, clientset *kubernetes.Clientset, namesp
 			continue
 		}
 
+		// charts running a prebuilt image have no configmap
 		configMap, hasConfigMap := configMapByUUID[serviceID]
-		if !hasConfigMap {
+		if !hasConfigMap && RuntimeOf(service.Labels) != RuntimeImage {
 			discovered = append(discovered, DiscoveredChart{
 				Error: fmt.Errorf("no configmap found for service %s with service-id %s", service.Name, serviceID),
 			})
@@ -117,7 +118,7 @@ func DiscoverCharts(ctx context.Context, clientset *kubernetes.Clientset, namesp
 			continue
 		}
 
-		logger.Debug("successfully reconstructed chart", "service", service.Name, "configmap", configMap.Name, "deployment", deployment.Name)
+		logger.Debug("successfully reconstructed chart", "service", service.Name, "runtime", RuntimeOf(service.Labels), "deployment", deployment.Name)
 
 		discovered = append(discovered, DiscoveredChart{
 			Chart: chart,
@@ -129,3 +130,19 @@ func DiscoverCharts(ctx context.Context, clientset *kubernetes.Clientset, namesp
 
 	return discovered
 }
+
+// FindServiceByName returns the name of the managed service that carries the given function name.
+// It returns empty string if no such service exists in the namespace.
+func FindServiceByName(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string) (string, error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true,%s=%s", LabelManagedBy, LabelFunctionName, name),
+	}
+	services, err := clientset.CoreV1().Services(namespace).List(ctx, listOptions)
+	if err != nil {
+		return "", fmt.Errorf("serviceClient.List(): %w", err)
+	}
+	if len(services.Items) == 0 {
+		return "", nil
+	}
+	return services.Items[0].Name, nil
+}
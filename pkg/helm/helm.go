@@ -7,15 +7,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"path"
+	"poorman-faas/pkg/policy"
+	"poorman-faas/pkg/tracing"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/attribute"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/yaml"
 )
@@ -25,8 +35,46 @@ const (
 	LabelManagedBy = "poorman-faas.io/managed"
 	// LabelServiceID is a label for linking related resources (supports selectors)
 	LabelServiceID = "poorman-faas.io/service-id"
+	// LabelFunctionName is a label for the optional human-readable name of the function (supports selectors)
+	LabelFunctionName = "poorman-faas.io/name"
+	// LabelRuntime is a label for how the function is run, see [RuntimePython] and [RuntimeImage]
+	LabelRuntime = "poorman-faas.io/runtime"
+	// LabelOwner is a label for the user who uploaded the function (supports selectors)
+	LabelOwner = "poorman-faas.io/user"
 )
 
+const (
+	// RuntimePython runs a Python bundle from a ConfigMap with uv
+	RuntimePython = "python"
+	// RuntimeImage runs a prebuilt container image
+	RuntimeImage = "image"
+	// DefaultPort is the container port a function serves HTTP on
+	DefaultPort = 8000
+)
+
+// RuntimeOf returns the runtime recorded in the labels, resources without one are Python.
+func RuntimeOf(labels map[string]string) string {
+	if runtime, exists := labels[LabelRuntime]; exists {
+		return runtime
+	}
+	return RuntimePython
+}
+
+const (
+	// AnnotationSource records where the code was fetched from
+	AnnotationSource = "poorman-faas.io/source"
+	// AnnotationSourceDigest records the resolved commit or content digest of the fetched code
+	AnnotationSourceDigest = "poorman-faas.io/source-digest"
+	// AnnotationPolicy records how the gateway serves the function, as JSON
+	AnnotationPolicy = "poorman-faas.io/policy"
+	// AnnotationCreatedAt records when the function was uploaded, as RFC 3339 with nanoseconds
+	AnnotationCreatedAt = "poorman-faas.io/created-at"
+)
+
+// reservedNamePrefix is the prefix of generated service names,
+// a function name MUST NOT start with it so that aliases never shadow a service.
+const reservedNamePrefix = "service-"
+
 // Chart hydrates various k8s resources via template.
 // These resources represent a Python Function as a Service (Faas), like a helm chart.
 //
@@ -47,14 +95,116 @@ type Chart struct {
 	configMapUUID  string
 	deploymentUUID string
 	serviceUUID    string
-	// user supplied python script
-	script []byte
+	// optional human-readable name, routable as /gateway/{name}
+	name string
+	// optional user who uploaded the function
+	owner string
+	// how the gateway serves the function
+	policy policy.Policy
+	// either RuntimePython or RuntimeImage
+	runtime string
+	// container port serving HTTP
+	port int32
+	// user supplied python files, for RuntimePython
+	bundle Bundle
+	// user supplied image, for RuntimeImage
+	image Image
 	// user supplied dot file
 	dotFile []byte
 	env     map[string]string
+	// annotations shared by all resources
+	annotations map[string]string
+	// when the function was uploaded, orders the revisions of a name
+	createdAt time.Time
+}
+
+// ChartOption configures optional parts of a Chart.
+type ChartOption func(c *Chart) error
+
+// WithName gives the Chart a human-readable name.
+//
+// The name MUST be a RFC-1035 label, and MUST NOT look like a generated service name.
+func WithName(name string) ChartOption {
+	return func(c *Chart) error {
+		if name == "" {
+			return nil
+		}
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			return fmt.Errorf("name %q is not RFC-1035 compliant: %s", name, strings.Join(errs, ", "))
+		}
+		if strings.HasPrefix(name, reservedNamePrefix) {
+			return fmt.Errorf("name %q MUST NOT start with %q", name, reservedNamePrefix)
+		}
+		c.name = name
+		return nil
+	}
 }
 
-func NewChart(namespace string, scriptBase64 string, dotFileBase64 string) (Chart, error) {
+// WithOwner records the user who uploaded the function.
+//
+// The owner MUST be a valid label value, as it is used for selectors and metrics.
+func WithOwner(owner string) ChartOption {
+	return func(c *Chart) error {
+		if owner == "" {
+			return nil
+		}
+		if errs := validation.IsValidLabelValue(owner); len(errs) > 0 {
+			return fmt.Errorf("owner %q is not a valid label value: %s", owner, strings.Join(errs, ", "))
+		}
+		c.owner = owner
+		return nil
+	}
+}
+
+// WithSource records where the code was fetched from, and the resolved commit or digest.
+func WithSource(origin string, digest string) ChartOption {
+	return func(c *Chart) error {
+		if origin == "" {
+			return nil
+		}
+		c.annotations[AnnotationSource] = origin
+		c.annotations[AnnotationSourceDigest] = digest
+		return nil
+	}
+}
+
+// WithPolicy records how the gateway serves the function, it is kept in the [AnnotationPolicy] annotation.
+func WithPolicy(p policy.Policy) ChartOption {
+	return func(c *Chart) error {
+		if p.IsZero() {
+			return nil
+		}
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		c.policy = p
+		c.annotations[AnnotationPolicy] = p.String()
+		return nil
+	}
+}
+
+// NewChart creates a Chart that runs the bundle's entrypoint with uv.
+func NewChart(namespace string, bundle Bundle, dotFileBase64 string, opts ...ChartOption) (Chart, error) {
+	// validate PEP 723 metadata of the entrypoint
+	if err := bundle.Validate(); err != nil {
+		return Chart{}, fmt.Errorf("bundle.Validate(): %w", err)
+	}
+
+	// ensure every file can be stored in the configmap
+	if _, err := bundle.configMapKeys(); err != nil {
+		return Chart{}, fmt.Errorf("bundle.configMapKeys(): %w", err)
+	}
+
+	chart, err := newChart(namespace, RuntimePython, dotFileBase64)
+	if err != nil {
+		return Chart{}, err
+	}
+	chart.bundle = bundle
+	return chart.apply(opts...)
+}
+
+// newChart generates resource names and parses the dot file shared by all runtimes.
+func newChart(namespace string, runtime string, dotFileBase64 string) (Chart, error) {
 	uuid := uuid.New().String()
 	// TODO: name should be RFC-1035 compliant
 	appName := fmt.Sprintf("app-%s", uuid)
@@ -62,49 +212,47 @@ func NewChart(namespace string, scriptBase64 string, dotFileBase64 string) (Char
 	deploymentUUID := fmt.Sprintf("deployment-%s", uuid)
 	serviceUUID := fmt.Sprintf("service-%s", uuid)
 
-	// decode base64 script
-	scriptBytes, err := base64.StdEncoding.DecodeString(scriptBase64)
-	if err != nil {
-		return Chart{}, fmt.Errorf("base64.DecodeString(script): %w", err)
-	}
-
 	// decode base64 dotFile
 	dotFileBytes, err := base64.StdEncoding.DecodeString(dotFileBase64)
 	if err != nil {
 		return Chart{}, fmt.Errorf("base64.DecodeString(dotFile): %w", err)
 	}
 
-	// validate PEP 723 metadata
-	schema, err := NewMetadata(string(scriptBytes))
-	if err != nil {
-		return Chart{}, fmt.Errorf("NewMetadata(): %w", err)
-	}
-
-	if !schema.Validate() {
-		return Chart{}, fmt.Errorf("script is not PEP 723 compliant")
-	}
-
 	// validate dot file
 	env, err := godotenv.Parse(bytes.NewReader(dotFileBytes))
 	if err != nil {
 		return Chart{}, fmt.Errorf("godotenv.Parse(): %w", err)
 	}
 
+	createdAt := time.Now().UTC()
 	return Chart{
 		appName:        appName,
 		Namespace:      namespace,
 		configMapUUID:  configMapUUID,
 		deploymentUUID: deploymentUUID,
 		serviceUUID:    serviceUUID,
-		script:         scriptBytes,
+		runtime:        runtime,
+		port:           DefaultPort,
 		dotFile:        dotFileBytes,
 		env:            env,
+		annotations:    map[string]string{AnnotationCreatedAt: createdAt.Format(time.RFC3339Nano)},
+		createdAt:      createdAt,
 	}, nil
 }
 
+func (s Chart) apply(opts ...ChartOption) (Chart, error) {
+	for _, opt := range opts {
+		if err := opt(&s); err != nil {
+			return Chart{}, err
+		}
+	}
+	return s, nil
+}
+
 // NewChartFromK8sResources reconstructs a Chart from existing k8s resources.
 // This is used for hydrating the reaper from existing cluster resources.
-// The script and dotFile fields will be empty as they are not needed for Teardown.
+// The bundle and dotFile fields will be empty as they are not needed for Teardown.
+// The configMap is nil for charts that run a prebuilt image.
 func NewChartFromK8sResources(configMap *apiv1.ConfigMap, deployment *appsv1.Deployment, service *apiv1.Service) (Chart, error) {
 	// Extract appName from the selector labels
 	appName := ""
@@ -117,7 +265,10 @@ func NewChartFromK8sResources(configMap *apiv1.ConfigMap, deployment *appsv1.Dep
 
 	// Extract UUIDs from resource names
 	// Names follow pattern: "configmap-{uuid}", "deployment-{uuid}", "service-{uuid}"
-	configMapUUID := configMap.Name
+	configMapUUID := ""
+	if configMap != nil {
+		configMapUUID = configMap.Name
+	}
 	deploymentUUID := deployment.Name
 	serviceUUID := service.Name
 
@@ -134,15 +285,38 @@ func NewChartFromK8sResources(configMap *apiv1.ConfigMap, deployment *appsv1.Dep
 		}
 	}
 
+	// resources created before runtimes existed are all Python
+	runtime := RuntimeOf(service.Labels)
+
+	port := int32(DefaultPort)
+	if len(service.Spec.Ports) > 0 && service.Spec.Ports[0].TargetPort.IntVal > 0 {
+		port = service.Spec.Ports[0].TargetPort.IntVal
+	}
+
+	// a policy that cannot be parsed falls back to the gateway defaults, rather than leaking the function
+	p, _ := policy.Parse(service.Annotations[AnnotationPolicy])
+
+	// resources created before the annotation existed are only precise to the second
+	createdAt, err := time.Parse(time.RFC3339Nano, service.Annotations[AnnotationCreatedAt])
+	if err != nil {
+		createdAt = service.CreationTimestamp.Time
+	}
+
 	return Chart{
 		appName:        appName,
 		Namespace:      service.Namespace,
 		configMapUUID:  configMapUUID,
 		deploymentUUID: deploymentUUID,
 		serviceUUID:    serviceUUID,
-		script:         nil, // not needed for Teardown
-		dotFile:        nil, // not needed for Teardown
+		name:           service.Labels[LabelFunctionName],
+		owner:          service.Labels[LabelOwner],
+		policy:         p,
+		runtime:        runtime,
+		port:           port,
+		bundle:         Bundle{}, // not needed for Teardown
+		dotFile:        nil,      // not needed for Teardown
 		env:            env,
+		createdAt:      createdAt,
 	}, nil
 }
 
@@ -152,23 +326,89 @@ func (s Chart) Selector() map[string]string {
 	}
 }
 
-// ConfigMap returns a ConfigMap object that contains the Python script.
+// Name returns the human-readable name of the Chart, or empty string if it has none.
+func (s Chart) Name() string {
+	return s.name
+}
+
+// Digest identifies the code of the Chart, the bundle digest or the image reference.
+// It is empty for a Chart discovered from the cluster, as the code is not read back.
+func (s Chart) Digest() string {
+	switch {
+	case s.runtime == RuntimeImage && s.image.Reference != "":
+		return s.image.Reference
+	case len(s.bundle.Files) > 0:
+		return s.bundle.Digest()
+	default:
+		return ""
+	}
+}
+
+// Owner returns the user who uploaded the Chart, or empty string if unknown.
+func (s Chart) Owner() string {
+	return s.owner
+}
+
+// Policy returns how the gateway serves the Chart.
+func (s Chart) Policy() policy.Policy {
+	return s.policy
+}
+
+// CreatedAt returns when the Chart was uploaded.
+func (s Chart) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// labels returns the labels shared by all managed resources of the Chart.
+func (s Chart) labels() map[string]string {
+	labels := map[string]string{
+		LabelManagedBy: "true",
+		LabelServiceID: s.serviceUUID,
+		LabelRuntime:   s.runtime,
+	}
+	if s.name != "" {
+		labels[LabelFunctionName] = s.name
+	}
+	if s.owner != "" {
+		labels[LabelOwner] = s.owner
+	}
+	return labels
+}
+
+// ConfigMap returns a ConfigMap object that contains the Python files.
+//
+// Each file is stored under its own key, binary files under BinaryData.
+// It returns nil for a Chart running a prebuilt image, see [RuntimeImage].
 //
 // A ConfigMap is an API object used to store non-confidential data in key-value pairs.
 // Pods can consume ConfigMaps as environment variables, command-line arguments, or
 // as configuration files in a volume. For more, see:
 // https://kubernetes.io/docs/concepts/configuration/configmap/
 func (s Chart) ConfigMap() *apiv1.ConfigMap {
+	if s.runtime == RuntimeImage {
+		return nil
+	}
+	// keys are validated in NewChart
+	keys, _ := s.bundle.configMapKeys()
+	data := make(map[string]string)
+	binaryData := make(map[string][]byte)
+	for p, key := range keys {
+		content := s.bundle.Files[p]
+		if utf8.Valid(content) {
+			data[key] = string(content)
+		} else {
+			binaryData[key] = content
+		}
+	}
 	return &apiv1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: s.Namespace,
-			Name:      s.configMapUUID,
-			Labels: map[string]string{
-				LabelManagedBy: "true",
-				LabelServiceID: s.serviceUUID,
-			},
+			Namespace:   s.Namespace,
+			Name:        s.configMapUUID,
+			Labels:      s.labels(),
+			Annotations: s.annotations,
 		},
-		Data: map[string]string{"main.py": string(s.script)},
+		Data:       data,
+		BinaryData: binaryData,
 	}
 }
 
@@ -178,7 +418,6 @@ func (s Chart) ConfigMap() *apiv1.ConfigMap {
 // usually one that doesn't maintain state. For more, see:
 // https://kubernetes.io/docs/concepts/workloads/controllers/deployment/
 func (s Chart) Deployment() *appsv1.Deployment {
-
 	envVars := make([]apiv1.EnvVar, 0, len(s.env))
 	for k, v := range s.env {
 		envVars = append(envVars, apiv1.EnvVar{
@@ -186,16 +425,86 @@ func (s Chart) Deployment() *appsv1.Deployment {
 			Value: v,
 		})
 	}
+	container := apiv1.Container{
+		Name: s.appName,
+		Ports: []apiv1.ContainerPort{{
+			ContainerPort: s.port,
+			Protocol:      apiv1.ProtocolTCP,
+		}},
+		Env: envVars,
+		StartupProbe: &apiv1.Probe{
+			ProbeHandler: apiv1.ProbeHandler{
+				HTTPGet: &apiv1.HTTPGetAction{
+					Path: "/health",
+					Port: intstr.FromInt32(s.port),
+				},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       5,
+			TimeoutSeconds:      3,
+			SuccessThreshold:    1,
+			FailureThreshold:    10, // 10 failures * 5s = 50s + 10s initial = 60s total
+		},
+		LivenessProbe: &apiv1.Probe{
+			ProbeHandler: apiv1.ProbeHandler{
+				HTTPGet: &apiv1.HTTPGetAction{
+					Path: "/health",
+					Port: intstr.FromInt32(s.port),
+				},
+			},
+			PeriodSeconds:    10,
+			TimeoutSeconds:   3,
+			SuccessThreshold: 1,
+			FailureThreshold: 3,
+		},
+	}
+
+	var volumes []apiv1.Volume
+	switch s.runtime {
+	case RuntimeImage:
+		container.Image = s.image.Reference
+		container.Command = s.image.Command
+		container.Args = s.image.Args
+	default:
+		// project each configmap key back to its path under /scripts
+		keys, _ := s.bundle.configMapKeys()
+		items := make([]apiv1.KeyToPath, 0, len(keys))
+		for _, p := range s.bundle.Paths() {
+			items = append(items, apiv1.KeyToPath{
+				Key:  keys[p],
+				Path: p,
+			})
+		}
+
+		container.Image = "ghcr.io/astral-sh/uv:python3.12-alpine"
+		container.Command = []string{"uv", "run", "--script", path.Join("/scripts", s.bundle.Entrypoint)}
+		container.WorkingDir = "/scripts"
+		container.VolumeMounts = []apiv1.VolumeMount{{
+			Name:      "script-volume",
+			MountPath: "/scripts",
+		}}
+		volumes = []apiv1.Volume{{
+			Name: "script-volume",
+			VolumeSource: apiv1.VolumeSource{
+				ConfigMap: &apiv1.ConfigMapVolumeSource{
+					LocalObjectReference: apiv1.LocalObjectReference{
+						Name: s.configMapUUID,
+					},
+					Items: items,
+				},
+			},
+		}}
+	}
+
 	return &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: s.Namespace,
-			Name:      s.deploymentUUID,
-			Labels: map[string]string{
-				LabelManagedBy: "true",
-				LabelServiceID: s.serviceUUID,
-			},
+			Namespace:   s.Namespace,
+			Name:        s.deploymentUUID,
+			Labels:      s.labels(),
+			Annotations: s.annotations,
 		},
 		Spec: appsv1.DeploymentSpec{
+			Replicas: s.replicas(),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: s.Selector(),
 			},
@@ -204,61 +513,28 @@ func (s Chart) Deployment() *appsv1.Deployment {
 					Labels: s.Selector(),
 				},
 				Spec: apiv1.PodSpec{
-					Containers: []apiv1.Container{{
-						Name:    s.appName,
-						Image:   "ghcr.io/astral-sh/uv:python3.12-alpine",
-						Command: []string{"uv", "run", "--script", "/scripts/main.py"},
-						Ports: []apiv1.ContainerPort{{
-							ContainerPort: 8000,
-							Protocol:      apiv1.ProtocolTCP,
-						}},
-						Env: envVars,
-						VolumeMounts: []apiv1.VolumeMount{{
-							Name:      "script-volume",
-							MountPath: "/scripts",
-						}},
-						StartupProbe: &apiv1.Probe{
-							ProbeHandler: apiv1.ProbeHandler{
-								HTTPGet: &apiv1.HTTPGetAction{
-									Path: "/health",
-									Port: intstr.FromInt(8000),
-								},
-							},
-							InitialDelaySeconds: 10,
-							PeriodSeconds:       5,
-							TimeoutSeconds:      3,
-							SuccessThreshold:    1,
-							FailureThreshold:    10, // 10 failures * 5s = 50s + 10s initial = 60s total
-						},
-						LivenessProbe: &apiv1.Probe{
-							ProbeHandler: apiv1.ProbeHandler{
-								HTTPGet: &apiv1.HTTPGetAction{
-									Path: "/health",
-									Port: intstr.FromInt(8000),
-								},
-							},
-							PeriodSeconds:    10,
-							TimeoutSeconds:   3,
-							SuccessThreshold: 1,
-							FailureThreshold: 3,
-						},
-					}},
-					Volumes: []apiv1.Volume{{
-						Name: "script-volume",
-						VolumeSource: apiv1.VolumeSource{
-							ConfigMap: &apiv1.ConfigMapVolumeSource{
-								LocalObjectReference: apiv1.LocalObjectReference{
-									Name: s.configMapUUID,
-								},
-							},
-						},
-					}},
+					Containers: []apiv1.Container{container},
+					Volumes:    volumes,
 				},
 			},
 		},
 	}
 }
 
+// replicas starts an autoscaled function at its minimum, others at the Kubernetes default.
+func (s Chart) replicas() *int32 {
+	if s.policy.MinReplicas > 0 {
+		replicas := int32(s.policy.MinReplicas)
+		return &replicas
+	}
+	return nil
+}
+
+// DeploymentName returns the name of the Deployment running the Chart.
+func (s Chart) DeploymentName() string {
+	return s.deploymentUUID
+}
+
 // Service returns a Service object that exposes the Python Faas.
 //
 // A Service is a method for exposing a network application
@@ -267,12 +543,10 @@ func (s Chart) Deployment() *appsv1.Deployment {
 func (s Chart) Service() *apiv1.Service {
 	return &apiv1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: s.Namespace,
-			Name:      s.serviceUUID,
-			Labels: map[string]string{
-				LabelManagedBy: "true",
-				LabelServiceID: s.serviceUUID,
-			},
+			Namespace:   s.Namespace,
+			Name:        s.serviceUUID,
+			Labels:      s.labels(),
+			Annotations: s.annotations,
 		},
 		Spec: apiv1.ServiceSpec{
 			// https://kubernetes.io/docs/concepts/services-networking/service/#publishing-services-service-types
@@ -281,7 +555,7 @@ func (s Chart) Service() *apiv1.Service {
 			Ports: []apiv1.ServicePort{{
 				Port:       80,
 				Protocol:   apiv1.ProtocolTCP,
-				TargetPort: intstr.FromInt(8000),
+				TargetPort: intstr.FromInt32(s.port),
 			}},
 		},
 	}
@@ -289,15 +563,20 @@ func (s Chart) Service() *apiv1.Service {
 
 // Deploy creates the Python Faas on the k8s cluster.
 //
-// creates in order: configmap -> deployment -> service
-func (s Chart) Deploy(ctx context.Context, clientset *kubernetes.Clientset) error {
+// creates in order: configmap (if any) -> deployment -> service
+func (s Chart) Deploy(ctx context.Context, clientset *kubernetes.Clientset) (err error) {
+	ctx, span := tracing.Start(ctx, "helm.Chart.Deploy", attribute.String("faas.service", s.serviceUUID))
+	defer func() { tracing.End(span, err) }()
+
 	ns := s.Namespace
-	configMapClient := clientset.CoreV1().ConfigMaps(ns)
-	// TODO: use Apply instead of Create?
-	// _, err := configMapClient.Apply(ctx, s.ConfigMap(), metav1.ApplyOptions{})
-	_, err := configMapClient.Create(ctx, s.ConfigMap(), metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("configMapClient.Create(): %w", err)
+	if cm := s.ConfigMap(); cm != nil {
+		configMapClient := clientset.CoreV1().ConfigMaps(ns)
+		// TODO: use Apply instead of Create?
+		// _, err := configMapClient.Apply(ctx, s.ConfigMap(), metav1.ApplyOptions{})
+		_, err := configMapClient.Create(ctx, cm, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("configMapClient.Create(): %w", err)
+		}
 	}
 	deploymentClient := clientset.AppsV1().Deployments(ns)
 	_, err = deploymentClient.Create(ctx, s.Deployment(), metav1.CreateOptions{})
@@ -317,6 +596,10 @@ func (s Chart) Deploy(ctx context.Context, clientset *kubernetes.Clientset) erro
 type ChartWrapper struct {
 	chart     *Chart
 	clientset *kubernetes.Clientset
+
+	mu sync.RWMutex
+	// policy set through [ChartWrapper.SetPolicy], over the one of the chart
+	policy *policy.Policy
 }
 
 // NewChartWrapper creates a new ChartWrapper.
@@ -337,13 +620,67 @@ func (cw *ChartWrapper) ServiceName() string {
 	return cw.chart.Service().Name
 }
 
+// Name returns the human-readable name for this chart, or empty string if it has none.
+func (cw *ChartWrapper) Name() string {
+	return cw.chart.Name()
+}
+
+// Owner returns the user who uploaded this chart, or empty string if unknown.
+func (cw *ChartWrapper) Owner() string {
+	return cw.chart.Owner()
+}
+
+// Policy returns how the gateway serves this chart.
+func (cw *ChartWrapper) Policy() policy.Policy {
+	cw.mu.RLock()
+	defer cw.mu.RUnlock()
+	if cw.policy != nil {
+		return *cw.policy
+	}
+	return cw.chart.Policy()
+}
+
+// SetPolicy replaces the policy of this chart, and persists it in the annotation of its Service.
+func (cw *ChartWrapper) SetPolicy(ctx context.Context, p policy.Policy) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{AnnotationPolicy: p.String()},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %w", err)
+	}
+	serviceClient := cw.clientset.CoreV1().Services(cw.chart.Namespace)
+	_, err = serviceClient.Patch(ctx, cw.ServiceName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("serviceClient.Patch(): %w", err)
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.policy = &p
+	return nil
+}
+
+// DeploymentName returns the name of the Deployment running this chart.
+func (cw *ChartWrapper) DeploymentName() string {
+	return cw.chart.DeploymentName()
+}
+
+// CreatedAt returns when this chart was uploaded.
+func (cw *ChartWrapper) CreatedAt() time.Time {
+	return cw.chart.CreatedAt()
+}
+
 // Teardown removes the Python Faas from the k8s cluster.
 //
-// destroys in reverse order: service -> deployment -> configmap
-func (s *Chart) Teardown(ctx context.Context, clientset *kubernetes.Clientset) error {
+// destroys in reverse order: service -> deployment -> configmap (if any)
+func (s *Chart) Teardown(ctx context.Context, clientset *kubernetes.Clientset) (err error) {
+	ctx, span := tracing.Start(ctx, "helm.Chart.Teardown", attribute.String("faas.service", s.serviceUUID))
+	defer func() { tracing.End(span, err) }()
+
 	ns := s.Namespace
 	serviceClient := clientset.CoreV1().Services(ns)
-	err := serviceClient.Delete(ctx, s.serviceUUID, metav1.DeleteOptions{})
+	err = serviceClient.Delete(ctx, s.serviceUUID, metav1.DeleteOptions{})
 	if err != nil {
 		return fmt.Errorf("serviceClient.Delete(): %w", err)
 	}
@@ -352,6 +689,9 @@ func (s *Chart) Teardown(ctx context.Context, clientset *kubernetes.Clientset) e
 	if err != nil {
 		return fmt.Errorf("deploymentClient.Delete(): %w", err)
 	}
+	if s.runtime == RuntimeImage {
+		return nil
+	}
 	configMapClient := clientset.CoreV1().ConfigMaps(ns)
 	err = configMapClient.Delete(ctx, s.configMapUUID, metav1.DeleteOptions{})
 	if err != nil {
@@ -373,9 +713,13 @@ func (s *Chart) ToYAML() (string, error) {
 	cm := s.ConfigMap()
 	deployment := s.Deployment()
 	service := s.Service()
-	cmYaml, err := yaml.Marshal(cm)
-	if err != nil {
-		return "", fmt.Errorf("yaml.Marshal(cm): %w", err)
+	var cmYaml []byte
+	if cm != nil {
+		yamlBytes, err := yaml.Marshal(cm)
+		if err != nil {
+			return "", fmt.Errorf("yaml.Marshal(cm): %w", err)
+		}
+		cmYaml = append(yamlBytes, []byte("---\n")...)
 	}
 	deploymentYaml, err := yaml.Marshal(deployment)
 	if err != nil {
@@ -386,5 +730,5 @@ func (s *Chart) ToYAML() (string, error) {
 		return "", fmt.Errorf("yaml.Marshal(service): %w", err)
 	}
 	// concat all yaml with triple dash to separate them
-	return fmt.Sprintf("%s---\n%s---\n%s", string(cmYaml), string(deploymentYaml), string(serviceYaml)), nil
+	return fmt.Sprintf("%s%s---\n%s", string(cmYaml), string(deploymentYaml), string(serviceYaml)), nil
 }
@@ -0,0 +1,89 @@
+package helm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// imageReferencePattern loosely follows the docker reference grammar:
+// [registry[:port]/]path[:tag][@sha256:digest]
+var imageReferencePattern = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*(?::[\w][\w.-]{0,127})?(?:@sha256:[a-f0-9]{64})?$`)
+
+// Image is a prebuilt container image that serves a Faas, instead of a Python bundle.
+//
+// Like a Python Faas, it MUST serve HTTP with a `/health` endpoint on Port.
+type Image struct {
+	// Reference is the image to pull, i.e. ghcr.io/org/echo:v1
+	Reference string `json:"reference"`
+	// Command overrides the image entrypoint.
+	Command []string `json:"command"`
+	// Args overrides the image command.
+	Args []string `json:"args"`
+	// Port is the container port serving HTTP, defaults to [DefaultPort].
+	Port int32 `json:"port"`
+}
+
+// Repository returns the fully qualified repository of the image without tag or digest,
+// i.e. nginx:latest is docker.io/library/nginx.
+func (img Image) Repository() string {
+	name := img.Reference
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	// a colon after the last slash separates the tag, others belong to the registry port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+
+	first, _, found := strings.Cut(name, "/")
+	switch {
+	case found && (strings.ContainsAny(first, ".:") || first == "localhost"):
+		return name
+	case found:
+		return "docker.io/" + name
+	default:
+		return "docker.io/library/" + name
+	}
+}
+
+// Validate checks the image is well formed, and pulled from one of the allowed registries.
+//
+// An allowed registry is either a host, i.e. ghcr.io, or a repository prefix, i.e. ghcr.io/org.
+// No image is allowed if the allowlist is empty.
+func (img Image) Validate(allowedRegistries []string) error {
+	if !imageReferencePattern.MatchString(img.Reference) {
+		return fmt.Errorf("image reference %q is invalid", img.Reference)
+	}
+	if img.Port < 0 || img.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", img.Port)
+	}
+
+	repository := img.Repository()
+	for _, allowed := range allowedRegistries {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if allowed != "" && strings.HasPrefix(repository+"/", allowed+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %q is not from an allowed registry", img.Reference)
+}
+
+// NewImageChart creates a Chart that runs a prebuilt image, it has no ConfigMap.
+//
+// The image is expected to be validated against the allowlist with [Image.Validate].
+func NewImageChart(namespace string, image Image, dotFileBase64 string, opts ...ChartOption) (Chart, error) {
+	if image.Reference == "" {
+		return Chart{}, fmt.Errorf("image reference is required")
+	}
+
+	chart, err := newChart(namespace, RuntimeImage, dotFileBase64)
+	if err != nil {
+		return Chart{}, err
+	}
+	chart.image = image
+	if image.Port > 0 {
+		chart.port = image.Port
+	}
+	return chart.apply(opts...)
+}
@@ -0,0 +1,90 @@
+package helm
+
+import "testing"
+
+func TestImageValidate(t *testing.T) {
+	allowed := []string{"ghcr.io/org", "localhost:5000"}
+	cases := []struct {
+		reference string
+		valid     bool
+	}{
+		{"ghcr.io/org/echo:v1", true},
+		{"ghcr.io/org/echo@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"localhost:5000/echo", true},
+		{"ghcr.io/organization/echo:v1", false},
+		{"ghcr.io/other/echo:v1", false},
+		{"nginx:latest", false},
+		{"ghcr.io/org/Echo", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		err := Image{Reference: c.reference}.Validate(allowed)
+		if c.valid && err != nil {
+			t.Errorf("Expected %q to be valid, got %v", c.reference, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("Expected %q to be invalid", c.reference)
+		}
+	}
+
+	if err := (Image{Reference: "ghcr.io/org/echo"}).Validate(nil); err == nil {
+		t.Error("Expected empty allowlist to reject all images")
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	cases := map[string]string{
+		"nginx:latest":             "docker.io/library/nginx",
+		"org/echo":                 "docker.io/org/echo",
+		"localhost:5000/echo:v1":   "localhost:5000/echo",
+		"ghcr.io/org/echo@sha256:": "ghcr.io/org/echo",
+	}
+	for reference, expected := range cases {
+		if got := (Image{Reference: reference}).Repository(); got != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, reference, got)
+		}
+	}
+}
+
+func TestNewImageChart(t *testing.T) {
+	chart, err := NewImageChart("faas", Image{
+		Reference: "ghcr.io/org/echo:v1",
+		Args:      []string{"--verbose"},
+		Port:      9000,
+	}, "", WithName("echo"))
+	if err != nil {
+		t.Fatalf("NewImageChart(): %v", err)
+	}
+	if chart.ConfigMap() != nil {
+		t.Error("Expected no configmap for an image chart")
+	}
+
+	podSpec := chart.Deployment().Spec.Template.Spec
+	if len(podSpec.Volumes) != 0 {
+		t.Errorf("Expected no volumes, got %d", len(podSpec.Volumes))
+	}
+	container := podSpec.Containers[0]
+	if container.Image != "ghcr.io/org/echo:v1" || container.Ports[0].ContainerPort != 9000 {
+		t.Errorf("Unexpected container %s on port %d", container.Image, container.Ports[0].ContainerPort)
+	}
+	if container.StartupProbe.HTTPGet.Port.IntVal != 9000 {
+		t.Errorf("Expected probes on port 9000, got %d", container.StartupProbe.HTTPGet.Port.IntVal)
+	}
+
+	service := chart.Service()
+	if service.Spec.Ports[0].TargetPort.IntVal != 9000 {
+		t.Errorf("Expected service to target port 9000, got %d", service.Spec.Ports[0].TargetPort.IntVal)
+	}
+	if RuntimeOf(service.Labels) != RuntimeImage {
+		t.Errorf("Expected image runtime label, got %v", service.Labels)
+	}
+
+	// rediscovering the chart without a configmap keeps the runtime and port
+	discovered, err := NewChartFromK8sResources(nil, chart.Deployment(), service)
+	if err != nil {
+		t.Fatalf("NewChartFromK8sResources(): %v", err)
+	}
+	if discovered.ConfigMap() != nil || discovered.Service().Spec.Ports[0].TargetPort.IntVal != 9000 {
+		t.Error("Expected rediscovered chart to match")
+	}
+}
@@ -0,0 +1,274 @@
+// Package invocation keeps a history of the recent calls to each function.
+//
+// Records are captured by the gateway proxy, see [Recorder], and kept in a [Store].
+package invocation
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Record is a single call to a function.
+type Record struct {
+	Service   string    `json:"service"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	// LatencyMs is the time until the function responded with headers.
+	LatencyMs int64 `json:"latency_ms"`
+	// DurationMs is the time until the response was fully sent, including streaming.
+	DurationMs   int64  `json:"duration_ms"`
+	RequestBody  string `json:"request_body,omitempty"`
+	ResponseBody string `json:"response_body,omitempty"`
+	// Truncated reports whether a body was longer than the capture limit.
+	Truncated bool `json:"truncated,omitempty"`
+	// Error is why the function could not be reached, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Store keeps invocation records, it MUST be safe for concurrent use.
+type Store interface {
+	// Add records an invocation.
+	Add(record Record) error
+	// List returns up to limit records of the service, newest first.
+	List(service string, limit int) ([]Record, error)
+}
+
+// Ring keeps the last records of each service in memory.
+type Ring struct {
+	size  int
+	mu    sync.RWMutex
+	rings map[string]*ring
+}
+
+type ring struct {
+	records []Record
+	next    int
+	full    bool
+}
+
+// NewRing creates a Ring that keeps the last size records per service.
+func NewRing(size int) *Ring {
+	return &Ring{
+		size:  max(size, 1),
+		rings: make(map[string]*ring),
+	}
+}
+
+// Add implements [Store].
+func (s *Ring) Add(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, exists := s.rings[record.Service]
+	if !exists {
+		r = &ring{records: make([]Record, s.size)}
+		s.rings[record.Service] = r
+	}
+	r.records[r.next] = record
+	r.next = (r.next + 1) % s.size
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+// List implements [Store].
+func (s *Ring) List(service string, limit int) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, exists := s.rings[service]
+	if !exists {
+		return []Record{}, nil
+	}
+	count := r.next
+	if r.full {
+		count = s.size
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	records := make([]Record, 0, count)
+	for i := 1; i <= count; i++ {
+		records = append(records, r.records[(r.next-i+s.size)%s.size])
+	}
+	return records, nil
+}
+
+// Forget drops the records of the service, i.e. once it is culled.
+func (s *Ring) Forget(service string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rings, service)
+}
+
+type contextKey struct{}
+
+// pending is the invocation being proxied, its record is only accessed by the goroutine serving the request.
+type pending struct {
+	record      Record
+	start       time.Time
+	requestBody *cappedBuffer
+	requestType string
+	response    *cappedBuffer
+	// responseType is the Content-Type of the response
+	responseType string
+}
+
+// Recorder captures invocation records from the gateway proxy.
+//
+// [Recorder.Middleware] starts a record, [Recorder.ModifyResponse] completes it when the function responds,
+// and [RecordError] when it cannot be reached.
+type Recorder struct {
+	store           Store
+	maxPayloadBytes int
+	onError         func(err error)
+	redact          func(contentType string, body []byte, truncated bool) string
+}
+
+type Option func(rec *Recorder)
+
+// WithRedact replaces the captured bodies by what redact returns, i.e. to mask credentials before they are stored.
+func WithRedact(redact func(contentType string, body []byte, truncated bool) string) Option {
+	return func(rec *Recorder) {
+		rec.redact = redact
+	}
+}
+
+// NewRecorder creates a Recorder that keeps up to maxPayloadBytes of the request and response bodies.
+//
+// onError is called when the store fails to add a record.
+func NewRecorder(store Store, maxPayloadBytes int, onError func(err error), opts ...Option) *Recorder {
+	rec := &Recorder{
+		store:           store,
+		maxPayloadBytes: maxPayloadBytes,
+		onError:         onError,
+	}
+	for _, opt := range opts {
+		opt(rec)
+	}
+	return rec
+}
+
+// Middleware records the requests to the proxy, getService returns the service of the request or false if unknown.
+func (rec *Recorder) Middleware(getService func(r *http.Request) (string, bool)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			service, exists := getService(r)
+			if !exists {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+			p := &pending{
+				record: Record{
+					Service:   service,
+					Method:    r.Method,
+					Path:      r.URL.Path,
+					Timestamp: now,
+				},
+				start:       now,
+				requestBody: &cappedBuffer{max: rec.maxPayloadBytes},
+				requestType: r.Header.Get("Content-Type"),
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &teeReadCloser{ReadCloser: r.Body, w: p.requestBody}
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), contextKey{}, p)))
+
+			p.record.DurationMs = time.Since(p.start).Milliseconds()
+			if p.record.Status == 0 {
+				// the function did not respond, the status is the one of the error handler
+				p.record.Status = ww.Status()
+			}
+			var requestTruncated, responseTruncated bool
+			p.record.RequestBody, requestTruncated = p.requestBody.snapshot()
+			if p.response != nil {
+				p.record.ResponseBody, responseTruncated = p.response.snapshot()
+			}
+			p.record.Truncated = requestTruncated || responseTruncated
+			if rec.redact != nil {
+				p.record.RequestBody = rec.redact(p.requestType, []byte(p.record.RequestBody), requestTruncated)
+				p.record.ResponseBody = rec.redact(p.responseType, []byte(p.record.ResponseBody), responseTruncated)
+			}
+			if err := rec.store.Add(p.record); err != nil && rec.onError != nil {
+				rec.onError(err)
+			}
+		})
+	}
+}
+
+// ModifyResponse records the response of the function, chain it in the proxy ModifyResponse.
+func (rec *Recorder) ModifyResponse(resp *http.Response) error {
+	p, exists := resp.Request.Context().Value(contextKey{}).(*pending)
+	if !exists {
+		return nil
+	}
+	p.record.Status = resp.StatusCode
+	p.record.LatencyMs = time.Since(p.start).Milliseconds()
+	p.response = &cappedBuffer{max: rec.maxPayloadBytes}
+	p.responseType = resp.Header.Get("Content-Type")
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// the body is an upgraded connection, i.e. a WebSocket, whose frames are not recorded
+		return nil
+	}
+	resp.Body = &teeReadCloser{ReadCloser: resp.Body, w: p.response}
+	return nil
+}
+
+// RecordError records why the function could not be reached, call it from the proxy ErrorHandler.
+func RecordError(r *http.Request, err error) {
+	if p, exists := r.Context().Value(contextKey{}).(*pending); exists {
+		p.record.Error = err.Error()
+	}
+}
+
+// cappedBuffer keeps the first max bytes written to it, and drops the rest.
+//
+// It is safe for concurrent use, as the transport may still be sending the request body when the response is done.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// snapshot returns what was kept so far, and whether some bytes were dropped.
+func (b *cappedBuffer) snapshot() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), b.truncated
+}
+
+// teeReadCloser copies what is read into w.
+type teeReadCloser struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	_, _ = t.w.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,128 @@
+package invocation
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"poorman-faas/pkg/proxy"
+	"strings"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	ring := NewRing(3)
+	for i := range 5 {
+		_ = ring.Add(Record{Service: "service-1", Path: fmt.Sprintf("/%d", i)})
+	}
+	_ = ring.Add(Record{Service: "service-2", Path: "/other"})
+
+	records, _ := ring.List("service-1", 0)
+	paths := make([]string, 0, len(records))
+	for _, record := range records {
+		paths = append(paths, record.Path)
+	}
+	if strings.Join(paths, ",") != "/4,/3,/2" {
+		t.Errorf("Expected the last 3 records newest first, got %v", paths)
+	}
+	if records, _ := ring.List("service-1", 1); len(records) != 1 || records[0].Path != "/4" {
+		t.Errorf("Expected the newest record only, got %v", records)
+	}
+
+	ring.Forget("service-1")
+	if records, _ := ring.List("service-1", 0); len(records) != 0 {
+		t.Errorf("Expected no records after Forget, got %d", len(records))
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer upstream.Close()
+
+	ring := NewRing(10)
+	recorder := NewRecorder(ring, 4, nil)
+	target, _ := url.Parse(upstream.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.ModifyResponse = recorder.ModifyResponse
+	handler := recorder.Middleware(func(r *http.Request) (string, bool) { return "service-1", true })(rp)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/gateway/echo/", strings.NewReader("ab")))
+
+	records, _ := ring.List("service-1", 0)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	record := records[0]
+	if record.Status != http.StatusAccepted || record.RequestBody != "ab" || record.ResponseBody != "0123" || !record.Truncated {
+		t.Errorf("Unexpected record %+v", record)
+	}
+}
+
+func TestRecorderRedact(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"user":{"password":"hunter2"}}`))
+	}))
+	defer upstream.Close()
+
+	ring := NewRing(10)
+	recorder := NewRecorder(ring, 1024, nil, WithRedact(proxy.NewRedactor("password", "token").Body))
+	target, _ := url.Parse(upstream.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.ModifyResponse = recorder.ModifyResponse
+	handler := recorder.Middleware(func(r *http.Request) (string, bool) { return "service-1", true })(rp)
+
+	req := httptest.NewRequest(http.MethodPost, "/gateway/echo/", strings.NewReader(`{"token":"secret","name":"echo"}`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	records, _ := ring.List("service-1", 0)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	record := records[0]
+	if record.RequestBody != `{"name":"echo","token":"[REDACTED]"}` {
+		t.Errorf("Expected the token to be redacted, got %s", record.RequestBody)
+	}
+	if record.ResponseBody != `{"user":{"password":"[REDACTED]"}}` {
+		t.Errorf("Expected the password to be redacted, got %s", record.ResponseBody)
+	}
+}
+
+func TestRecorderUpgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		_ = rw.Flush()
+	}))
+	defer upstream.Close()
+
+	ring := NewRing(10)
+	recorder := NewRecorder(ring, 4, nil)
+	target, _ := url.Parse(upstream.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.ModifyResponse = recorder.ModifyResponse
+	gateway := httptest.NewServer(recorder.Middleware(func(r *http.Request) (string, bool) { return "service-1", true })(rp))
+	defer gateway.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http.DefaultClient.Do(): %v", err)
+	}
+	_ = resp.Body.Close()
+	// the body of an upgraded connection stays writable, or the proxy fails with 502
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+}
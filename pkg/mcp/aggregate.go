@@ -0,0 +1,499 @@
+package mcp
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"poorman-faas/pkg/traffic"
+	"poorman-faas/pkg/util"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ToolSeparator joins the route of a function and the name of its tool, i.e. `echo__echo`.
+//
+// Routes are RFC-1035 labels, so they never contain it.
+const ToolSeparator = "__"
+
+// ProtocolVersion is the MCP version the gateway speaks when the client does not ask for one.
+const ProtocolVersion = "2025-03-26"
+
+const (
+	// initializeID is the id of the initialize requests to backends.
+	initializeID = 0
+	// requestID is the id of the other requests to backends, each request has a response stream of its own.
+	requestID = 1
+)
+
+// DefaultMaxResponseBytes caps the responses of backends, which are buffered in memory.
+const DefaultMaxResponseBytes = 10 << 20
+
+// Function is an MCP function aggregated under the tools of its owner.
+type Function struct {
+	// Service is the revision that serves the tools, the calls are pinned to it.
+	Service string
+	// Route namespaces the tools of the function, its human-readable name or its service name.
+	Route string
+}
+
+// Aggregator is an MCP server that merges the tools of all the MCP functions of a user.
+//
+// Calls to backends go through the gateway, so that they are rate limited, recorded and pinned like any other call.
+type Aggregator struct {
+	getFunctions func(user string) []Function
+	backend      http.Handler
+	pathOf       func(route string) string
+	logger       *slog.Logger
+	ttl          time.Duration
+	maxBytes     int64
+
+	mu sync.Mutex
+	// sessions with each backend by caller, see [callerOf]
+	sessions map[string]map[string]*backendSession
+}
+
+// backendSession is a session of the gateway with a backend on behalf of a caller, its id is empty for stateless backends.
+type backendSession struct {
+	id       string
+	lastUsed time.Time
+}
+
+type AggregatorOption func(a *Aggregator)
+
+// WithBackendSessionTTL forgets the sessions with backends idle for longer than ttl, defaults to 1 hour.
+func WithBackendSessionTTL(ttl time.Duration) AggregatorOption {
+	return func(a *Aggregator) {
+		a.ttl = ttl
+	}
+}
+
+// WithMaxResponseBytes fails the calls to backends that respond with more than maxBytes, defaults to [DefaultMaxResponseBytes].
+func WithMaxResponseBytes(maxBytes int64) AggregatorOption {
+	return func(a *Aggregator) {
+		a.maxBytes = maxBytes
+	}
+}
+
+// NewAggregator creates an Aggregator.
+//
+// getFunctions returns the MCP functions of a user, backend serves the gateway, and pathOf is the MCP path of a route on it.
+func NewAggregator(getFunctions func(user string) []Function, backend http.Handler, pathOf func(route string) string, logger *slog.Logger, opts ...AggregatorOption) *Aggregator {
+	a := &Aggregator{
+		getFunctions: getFunctions,
+		backend:      backend,
+		pathOf:       pathOf,
+		logger:       logger,
+		ttl:          time.Hour,
+		maxBytes:     DefaultMaxResponseBytes,
+		sessions:     make(map[string]map[string]*backendSession),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// callerOf identifies the caller of r, by client IP and credentials, as the headers of the caller initialize a session.
+// Callers never share a session, nor the state a backend keeps in it.
+func callerOf(r *http.Request) string {
+	return util.ClientIP(r) + "/" + util.CredentialFingerprint(r)
+}
+
+// Forget drops the sessions with the function, i.e. once it is culled.
+func (a *Aggregator) Forget(service string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, service)
+}
+
+func (a *Aggregator) forget(service string, caller string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions[service], caller)
+}
+
+// store records a new session, and drops the expired ones.
+func (a *Aggregator) store(service string, caller string, id string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, sessions := range a.sessions {
+		for expired, s := range sessions {
+			if now.Sub(s.lastUsed) > a.ttl {
+				delete(sessions, expired)
+			}
+		}
+	}
+	if a.sessions[service] == nil {
+		a.sessions[service] = make(map[string]*backendSession)
+	}
+	a.sessions[service][caller] = &backendSession{id: id, lastUsed: now}
+}
+
+// Handler serves the aggregated MCP server of the user returned by getUser, as stateless streamable HTTP.
+func (a *Aggregator) Handler(getUser func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			WriteError(w, http.StatusMethodNotAllowed, nil, CodeInvalidRequest, "only POST is supported", nil)
+			return
+		}
+		body, err := readBody(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, nil, CodeInvalidRequest, "failed to read the request body", nil)
+			return
+		}
+		messages, err := Parse(body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, nil, CodeParseError, err.Error(), nil)
+			return
+		}
+		if len(messages) != 1 {
+			WriteError(w, http.StatusBadRequest, nil, CodeInvalidRequest, "batches are not supported", nil)
+			return
+		}
+		message := messages[0]
+		if message.ID == nil {
+			// notifications and responses of the client need no answer
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		user := getUser(r)
+		var result any
+		var rpcErr *Error
+		switch message.Method {
+		case "initialize":
+			result = a.initialize(message)
+		case "ping":
+			result = struct{}{}
+		case "tools/list":
+			result = a.listTools(r, user)
+		case "tools/call":
+			result, rpcErr = a.callTool(r, user, message)
+		default:
+			rpcErr = &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q is not supported", message.Method)}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := Response{JSONRPC: "2.0", ID: message.ID, Error: rpcErr}
+		if rpcErr == nil {
+			response.Result, _ = json.Marshal(result)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	})
+}
+
+func (a *Aggregator) initialize(message Message) any {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	_ = json.Unmarshal(message.Params, &params)
+	return map[string]any{
+		"protocolVersion": cmp.Or(params.ProtocolVersion, ProtocolVersion),
+		"capabilities":    map[string]any{"tools": map[string]any{}},
+		"serverInfo":      map[string]any{"name": "poorman-faas", "version": "1.0.0"},
+	}
+}
+
+// listTools merges the tools of the functions of the user, a function that fails is skipped.
+func (a *Aggregator) listTools(r *http.Request, user string) any {
+	functions := a.getFunctions(user)
+	slices.SortFunc(functions, func(a, b Function) int { return strings.Compare(a.Route, b.Route) })
+	listed := make([][]map[string]json.RawMessage, len(functions))
+	var wg sync.WaitGroup
+	for i, function := range functions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tools, err := a.backendTools(r, function)
+			if err != nil {
+				a.logger.Warn("Failed to list the tools of the MCP function", "service", function.Service, "error", err)
+				return
+			}
+			listed[i] = tools
+		}()
+	}
+	wg.Wait()
+
+	tools := []map[string]json.RawMessage{}
+	for i, function := range functions {
+		for _, tool := range listed[i] {
+			var name string
+			if err := json.Unmarshal(tool["name"], &name); err != nil || name == "" {
+				continue
+			}
+			tool["name"], _ = json.Marshal(function.Route + ToolSeparator + name)
+			tools = append(tools, tool)
+		}
+	}
+	return map[string]any{"tools": tools}
+}
+
+// backendTools lists all the pages of tools of the function.
+func (a *Aggregator) backendTools(r *http.Request, function Function) ([]map[string]json.RawMessage, error) {
+	var tools []map[string]json.RawMessage
+	cursor := ""
+	for {
+		params := map[string]string{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		resp, err := a.request(r, function, "tools/list", params)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("tools/list: %s", resp.Error.Message)
+		}
+		var result struct {
+			Tools      []map[string]json.RawMessage `json:"tools"`
+			NextCursor string                       `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal(): %w", err)
+		}
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" || result.NextCursor == cursor {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// callTool routes the call to the function that the tool is namespaced with.
+func (a *Aggregator) callTool(r *http.Request, user string, message Message) (any, *Error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(message.Params, &params); err != nil || params == nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "params MUST be an object"}
+	}
+	var name string
+	_ = json.Unmarshal(params["name"], &name)
+	route, tool, found := strings.Cut(name, ToolSeparator)
+	if !found {
+		return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("tool %q is not namespaced with a function", name)}
+	}
+	functions := a.getFunctions(user)
+	index := slices.IndexFunc(functions, func(f Function) bool { return f.Route == route })
+	if index < 0 {
+		return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("function %q does not exist", route)}
+	}
+	function := functions[index]
+	params["name"], _ = json.Marshal(tool)
+	a.logger.Info("MCP aggregated call", "user", user, "service", function.Service, "tool", tool)
+	resp, err := a.request(r, function, "tools/call", params)
+	if err != nil {
+		return nil, &Error{Code: CodeUnavailable, Message: err.Error()}
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Result, nil
+}
+
+// request sends a request to the function, and initializes a session with it first if needed.
+func (a *Aggregator) request(r *http.Request, function Function, method string, params any) (Response, error) {
+	for attempt := 0; ; attempt++ {
+		session, err := a.session(r, function)
+		if err != nil {
+			return Response{}, err
+		}
+		resp, status, err := a.send(r, function, session, method, params)
+		if status == http.StatusNotFound && session != "" && attempt == 0 {
+			// the session expired, initialize a new one
+			a.forget(function.Service, callerOf(r))
+			continue
+		}
+		return resp, err
+	}
+}
+
+// session returns the session of the caller of r with the function, empty if it is stateless.
+func (a *Aggregator) session(r *http.Request, function Function) (string, error) {
+	caller := callerOf(r)
+	now := time.Now()
+	a.mu.Lock()
+	if s, exists := a.sessions[function.Service][caller]; exists && now.Sub(s.lastUsed) <= a.ttl {
+		s.lastUsed = now
+		a.mu.Unlock()
+		return s.id, nil
+	}
+	a.mu.Unlock()
+	resp, header, err := a.post(r, function, "", map[string]any{
+		"jsonrpc": "2.0",
+		"id":      initializeID,
+		"method":  "initialize",
+		"params": map[string]any{
+			"protocolVersion": ProtocolVersion,
+			"capabilities":    map[string]any{},
+			"clientInfo":      map[string]any{"name": "poorman-faas", "version": "1.0.0"},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Error != nil {
+		return "", fmt.Errorf("initialize: %s", resp.Error.Message)
+	}
+	session := header.Get(HeaderSessionID)
+	if _, _, err := a.post(r, function, session, map[string]any{"jsonrpc": "2.0", "method": "notifications/initialized"}); err != nil {
+		return "", err
+	}
+	a.store(function.Service, caller, session, now)
+	return session, nil
+}
+
+func (a *Aggregator) send(r *http.Request, function Function, session string, method string, params any) (Response, int, error) {
+	rec, err := a.serve(r, function, session, map[string]any{"jsonrpc": "2.0", "id": requestID, "method": method, "params": params})
+	if err != nil {
+		return Response{}, 0, err
+	}
+	resp, err := rec.response(requestID)
+	return resp, rec.code, err
+}
+
+func (a *Aggregator) post(r *http.Request, function Function, session string, message any) (Response, http.Header, error) {
+	rec, err := a.serve(r, function, session, message)
+	if err != nil {
+		return Response{}, nil, err
+	}
+	if rec.code == http.StatusAccepted {
+		return Response{}, rec.header, nil
+	}
+	// the only request posted is initialize
+	resp, err := rec.response(initializeID)
+	return resp, rec.header, err
+}
+
+// serve sends the message to the function through the gateway, on behalf of the caller of r.
+func (a *Aggregator) serve(r *http.Request, function Function, session string, message any) (*responseBuffer, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal(): %w", err)
+	}
+	// without a server in the context, the proxy ends the call on a failed write instead of aborting the connection of the caller
+	ctx := context.WithValue(r.Context(), http.ServerContextKey, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.pathOf(function.Route), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext(): %w", err)
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Del(HeaderSessionID)
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if session != "" {
+		req.Header.Set(HeaderSessionID, session)
+	}
+	// the session belongs to the revision, the splitter would otherwise pick one for each call
+	req.Header.Set(traffic.HeaderRevision, function.Service)
+	// the caller is rate limited as if it called the function directly
+	req.RemoteAddr = r.RemoteAddr
+	rec := &responseBuffer{header: make(http.Header), max: a.maxBytes}
+	a.backend.ServeHTTP(rec, req)
+	if rec.exceeded {
+		return nil, fmt.Errorf("function responded with more than %d bytes", a.maxBytes)
+	}
+	return rec, nil
+}
+
+// errResponseTooLarge stops copying the response of a backend, see [responseBuffer].
+var errResponseTooLarge = errors.New("response too large")
+
+// responseBuffer is a [http.ResponseWriter] that keeps up to max bytes of the response of a backend in memory.
+type responseBuffer struct {
+	header   http.Header
+	code     int
+	body     bytes.Buffer
+	max      int64
+	exceeded bool
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	if int64(b.body.Len()+len(p)) > b.max {
+		b.exceeded = true
+		return 0, errResponseTooLarge
+	}
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+// response decodes the JSON-RPC response to the request id, from a JSON body or server-sent events.
+//
+// The events sent before the response, i.e. progress or log notifications, are skipped.
+func (b *responseBuffer) response(id int) (Response, error) {
+	mediaType, _, _ := mime.ParseMediaType(b.header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		return decodeResponse(b.code, b.body.Bytes())
+	}
+	for _, data := range eventData(b.body.Bytes()) {
+		var message Message
+		if json.Unmarshal(data, &message) != nil || message.Method != "" || string(message.ID) != strconv.Itoa(id) {
+			continue
+		}
+		return decodeResponse(b.code, data)
+	}
+	return Response{}, fmt.Errorf("function responded %d with no JSON-RPC response to request %d", b.code, id)
+}
+
+func decodeResponse(code int, data []byte) (Response, error) {
+	var resp Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return Response{}, fmt.Errorf("function responded %d with no JSON-RPC response: %w", code, err)
+	}
+	if resp.Error == nil && resp.Result == nil {
+		return Response{}, errors.New("function responded with an empty JSON-RPC response")
+	}
+	return resp, nil
+}
+
+// eventData returns the data of each server-sent event, the `data:` lines of an event are joined with newlines.
+func eventData(body []byte) [][]byte {
+	var events [][]byte
+	var data []byte
+	hasData := false
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			// a blank line ends the event
+			if hasData {
+				events = append(events, data)
+			}
+			data, hasData = nil, false
+			continue
+		}
+		value, found := bytes.CutPrefix(line, []byte("data:"))
+		if !found {
+			// other fields and comments carry no data
+			continue
+		}
+		if hasData {
+			data = append(data, '\n')
+		}
+		data = append(data, bytes.TrimPrefix(value, []byte(" "))...)
+		hasData = true
+	}
+	if hasData {
+		events = append(events, data)
+	}
+	return events
+}
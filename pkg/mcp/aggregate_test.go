@@ -0,0 +1,171 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"poorman-faas/pkg/traffic"
+	"strings"
+	"testing"
+)
+
+// newBackend is an MCP function with a single tool, stateful ones issue sessions and answer JSON,
+// stateless ones answer server-sent events, with a notification first and the response over several lines.
+func newBackend(tool string, stateful bool) http.HandlerFunc {
+	sessions := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		var message Message
+		_ = json.NewDecoder(r.Body).Decode(&message)
+		if message.ID == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		var result string
+		switch message.Method {
+		case "initialize":
+			if stateful {
+				sessions++
+				w.Header().Set(HeaderSessionID, fmt.Sprintf("session-%d", sessions))
+			}
+			result = `{"protocolVersion":"2025-03-26","capabilities":{"tools":{}}}`
+		case "tools/list":
+			result = fmt.Sprintf(`{"tools":[{"name":%q,"inputSchema":{"type":"object"}}]}`, tool)
+		case "tools/call":
+			if stateful && r.Header.Get(HeaderSessionID) != fmt.Sprintf("session-%d", sessions) {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			var params struct {
+				Name string `json:"name"`
+			}
+			_ = json.Unmarshal(message.Params, &params)
+			result = fmt.Sprintf(`{"content":[{"type":"text","text":"called %s"}]}`, params.Name)
+		}
+		response := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, message.ID, result)
+		if stateful {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(response))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+		_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", strings.Replace(response, `,"result":`, ",\ndata: \"result\":", 1))
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	gateway := http.NewServeMux()
+	alpha := newBackend("echo", true)
+	gateway.HandleFunc("/gateway/alpha/mcp", func(w http.ResponseWriter, r *http.Request) {
+		if revision := r.Header.Get(traffic.HeaderRevision); revision != "faas-1" {
+			t.Errorf("Expected the call to be pinned to faas-1, got %q", revision)
+		}
+		alpha(w, r)
+	})
+	gateway.Handle("/gateway/beta/mcp", newBackend("echo", false))
+	a := NewAggregator(
+		func(user string) []Function {
+			if user != "alice" {
+				return nil
+			}
+			return []Function{{Service: "faas-2", Route: "beta"}, {Service: "faas-1", Route: "alpha"}}
+		},
+		gateway,
+		func(route string) string { return "/gateway/" + route + "/mcp" },
+		slog.New(slog.DiscardHandler),
+	)
+	apiKey := "key-1"
+	server := httptest.NewServer(a.Handler(func(r *http.Request) string { return r.URL.Query().Get("user") }))
+	defer server.Close()
+	call := func(user string, body string) Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"?user="+user, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", apiKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("client.Do(): %v", err)
+		}
+		defer resp.Body.Close()
+		var decoded Response
+		_ = json.NewDecoder(resp.Body).Decode(&decoded)
+		return decoded
+	}
+
+	resp := call("alice", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	var listed struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	_ = json.Unmarshal(resp.Result, &listed)
+	if len(listed.Tools) != 2 || listed.Tools[0].Name != "alpha__echo" || listed.Tools[1].Name != "beta__echo" {
+		t.Errorf("Expected the namespaced tools of both functions, got %s", resp.Result)
+	}
+	if resp := call("bob", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`); string(resp.Result) != `{"tools":[]}` {
+		t.Errorf("Expected no tools for another user, got %s", resp.Result)
+	}
+
+	for _, route := range []string{"alpha", "beta"} {
+		resp := call("alice", fmt.Sprintf(`{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"%s__echo","arguments":{}}}`, route))
+		if resp.Error != nil || string(resp.ID) != `"call"` || !strings.Contains(string(resp.Result), "called echo") {
+			t.Errorf("Expected the call of %s to reach its function, got %+v", route, resp)
+		}
+	}
+
+	// callers never share a session
+	apiKey = "key-2"
+	if resp := call("alice", `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"alpha__echo"}}`); resp.Error != nil {
+		t.Errorf("Expected the call of another caller to succeed, got %+v", resp.Error)
+	}
+	a.mu.Lock()
+	if sessions := len(a.sessions["faas-1"]); sessions != 2 {
+		t.Errorf("Expected a session per caller, got %d", sessions)
+	}
+	a.mu.Unlock()
+
+	// a session that the function no longer knows is initialized again
+	a.mu.Lock()
+	for _, s := range a.sessions["faas-1"] {
+		s.id = "expired"
+	}
+	a.mu.Unlock()
+	if resp := call("alice", `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"alpha__echo"}}`); resp.Error != nil {
+		t.Errorf("Expected the call to succeed with a new session, got %+v", resp.Error)
+	}
+
+	resp = call("alice", `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"gamma__echo"}}`)
+	if resp.Error == nil || resp.Error.Code != CodeInvalidParams {
+		t.Errorf("Expected an invalid params error for an unknown function, got %+v", resp)
+	}
+}
+
+func TestAggregatorMaxResponseBytes(t *testing.T) {
+	upstream := httptest.NewServer(newBackend("echo", false))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	a := NewAggregator(
+		func(string) []Function { return []Function{{Service: "faas-1", Route: "alpha"}} },
+		httputil.NewSingleHostReverseProxy(target),
+		func(string) string { return "/" },
+		slog.New(slog.DiscardHandler),
+		WithMaxResponseBytes(64),
+	)
+	server := httptest.NewServer(a.Handler(func(*http.Request) string { return "alice" }))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"alpha__echo"}}`))
+	if err != nil {
+		t.Fatalf("http.Post(): %v", err)
+	}
+	defer resp.Body.Close()
+	var decoded Response
+	_ = json.NewDecoder(resp.Body).Decode(&decoded)
+	if decoded.Error == nil || decoded.Error.Code != CodeUnavailable || !strings.Contains(decoded.Error.Message, "more than 64 bytes") {
+		t.Errorf("Expected the call to fail once the response exceeds the cap, got %+v", decoded)
+	}
+}
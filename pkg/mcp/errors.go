@@ -0,0 +1,80 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"poorman-faas/pkg/proxy"
+)
+
+// readBody reads the request body, and restores it to be proxied.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// errorWriter turns the errors of the gateway, i.e. [proxy.WriteError], into JSON-RPC errors.
+//
+// Responses of the function are written as is.
+type errorWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	code        int
+	// buffered is the gateway error, if any
+	buffered *bytes.Buffer
+}
+
+func (w *errorWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code >= http.StatusBadRequest && w.Header().Get(proxy.HeaderError) != "" {
+		w.code = code
+		w.buffered = &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffered != nil {
+		return w.buffered.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush streams the responses of the function, i.e. server-sent events.
+func (w *errorWriter) Flush() {
+	if w.buffered == nil {
+		_ = http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap lets [http.ResponseController] hijack the connection.
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the buffered gateway error as a JSON-RPC error to the request id.
+func (w *errorWriter) finish(id json.RawMessage) {
+	if w.buffered == nil {
+		return
+	}
+	var resp proxy.ErrorResponse
+	if err := json.Unmarshal(w.buffered.Bytes(), &resp); err != nil {
+		resp = proxy.ErrorResponse{Code: w.code, Message: w.buffered.String()}
+	}
+	WriteError(w.ResponseWriter, w.code, id, CodeUnavailable, resp.Message, resp)
+}
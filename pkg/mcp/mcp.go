@@ -0,0 +1,339 @@
+// Package mcp serves functions that are streamable HTTP MCP servers.
+//
+// The gateway parses their JSON-RPC requests to log the tools called, pins each session to the pod that issued it,
+// and answers JSON-RPC errors when the function is unavailable.
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"poorman-faas/pkg/proxy"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HeaderSessionID is issued by the MCP server on initialize, and sent back by the client on every next request.
+const HeaderSessionID = "Mcp-Session-Id"
+
+// JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	// CodeUnavailable is returned when the gateway could not get a response from the function.
+	CodeUnavailable = -32000
+	// CodeSessionNotFound is returned for unknown or expired sessions, the client MUST initialize a new one.
+	CodeSessionNotFound = -32001
+)
+
+// Message is a JSON-RPC request, notification or response sent by the client.
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// ToolName returns the name of the tool called, or an empty string if the message is not a `tools/call`.
+func (m Message) ToolName() string {
+	if m.Method != "tools/call" {
+		return ""
+	}
+	var params struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(m.Params, &params)
+	return params.Name
+}
+
+// Parse decodes a single JSON-RPC message, or a batch of them.
+func Parse(body []byte) ([]Message, error) {
+	body = bytes.TrimSpace(body)
+	var messages []Message
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &messages); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal(): %w", err)
+		}
+		if len(messages) == 0 {
+			return nil, fmt.Errorf("batch MUST NOT be empty")
+		}
+	} else {
+		var message Message
+		if err := json.Unmarshal(body, &message); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal(): %w", err)
+		}
+		messages = []Message{message}
+	}
+	for _, message := range messages {
+		if message.JSONRPC != "2.0" {
+			return nil, fmt.Errorf("jsonrpc MUST be 2.0, got %q", message.JSONRPC)
+		}
+	}
+	return messages, nil
+}
+
+// Error is the error of a JSON-RPC response.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// Response is a JSON-RPC response.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// WriteError writes a JSON-RPC error response to the request id, null if unknown.
+func WriteError(w http.ResponseWriter, status int, id json.RawMessage, code int, message string, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(Response{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &Error{Code: code, Message: message, Data: data},
+	})
+}
+
+type session struct {
+	// endpoint is the pod that issued the session, empty if it is reached through the Service
+	endpoint string
+	lastSeen time.Time
+}
+
+// call is the MCP request in flight, kept in its context.
+type call struct {
+	service  string
+	session  string
+	endpoint string
+}
+
+type contextKey struct{}
+
+// Endpoint returns the pod that the request MUST reach, or an empty string if any pod of the Service will do.
+func Endpoint(r *http.Request) string {
+	if c, exists := r.Context().Value(contextKey{}).(*call); exists {
+		return c.endpoint
+	}
+	return ""
+}
+
+// Gateway pins the sessions of MCP functions to the pod that issued them.
+//
+// [Gateway.Middleware] parses the requests, [Gateway.ModifyResponse] and [Gateway.OnError] track the sessions.
+type Gateway struct {
+	getEndpoints func(ctx context.Context, service string) ([]string, error)
+	logger       *slog.Logger
+	ttl          time.Duration
+	next         atomic.Uint64
+
+	mu       sync.Mutex
+	sessions map[string]map[string]*session
+}
+
+type Option func(g *Gateway)
+
+// WithSessionTTL forgets the sessions idle for longer than ttl, defaults to 1 hour.
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(g *Gateway) {
+		g.ttl = ttl
+	}
+}
+
+// New creates a Gateway, getEndpoints returns the `{ip}:{port}` of the ready pods of a function.
+func New(getEndpoints func(ctx context.Context, service string) ([]string, error), logger *slog.Logger, opts ...Option) *Gateway {
+	g := &Gateway{
+		getEndpoints: getEndpoints,
+		logger:       logger,
+		ttl:          time.Hour,
+		sessions:     make(map[string]map[string]*session),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// lookup returns the session of the function, and refreshes it.
+func (g *Gateway) lookup(service string, id string, now time.Time) (*session, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, exists := g.sessions[service][id]
+	if !exists || now.Sub(s.lastSeen) > g.ttl {
+		delete(g.sessions[service], id)
+		return nil, false
+	}
+	s.lastSeen = now
+	return s, true
+}
+
+// pin records the endpoint of a new session, and drops the expired ones.
+func (g *Gateway) pin(service string, id string, endpoint string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, sessions := range g.sessions {
+		for expiredID, s := range sessions {
+			if now.Sub(s.lastSeen) > g.ttl {
+				delete(sessions, expiredID)
+			}
+		}
+	}
+	if g.sessions[service] == nil {
+		g.sessions[service] = make(map[string]*session)
+	}
+	g.sessions[service][id] = &session{endpoint: endpoint, lastSeen: now}
+}
+
+func (g *Gateway) unpin(service string, id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions[service], id)
+}
+
+// Sessions returns how many sessions of the function are pinned.
+func (g *Gateway) Sessions(service string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.sessions[service])
+}
+
+// Forget drops the sessions of the function, i.e. once it is culled.
+func (g *Gateway) Forget(service string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, service)
+}
+
+// pick returns a ready pod of the function in turn, or an empty string to go through the Service.
+func (g *Gateway) pick(ctx context.Context, service string) string {
+	endpoints, err := g.getEndpoints(ctx, service)
+	if err != nil {
+		g.logger.Warn("Failed to list the endpoints of the MCP function", "service", service, "error", err)
+		return ""
+	}
+	if len(endpoints) == 0 {
+		return ""
+	}
+	return endpoints[(g.next.Add(1)-1)%uint64(len(endpoints))]
+}
+
+// Middleware serves the functions that getFunction reports as MCP servers, others are passed through.
+//
+// Chain it after [proxy.LimitBody], as it reads the request body, and before the middlewares that can reject a request,
+// so that their errors are JSON-RPC errors.
+func (g *Gateway) Middleware(getFunction func(r *http.Request) (service string, enabled bool)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			service, enabled := getFunction(r)
+			if !enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c := &call{service: service, session: r.Header.Get(HeaderSessionID)}
+			var id json.RawMessage
+			initialize := false
+			if r.Method == http.MethodPost {
+				body, err := readBody(r)
+				var maxBytesErr *http.MaxBytesError
+				switch {
+				case errors.As(err, &maxBytesErr):
+					w.Header().Set(proxy.HeaderError, string(proxy.ErrBodyTooLarge))
+					WriteError(w, http.StatusRequestEntityTooLarge, nil, CodeInvalidRequest, fmt.Sprintf("request body MUST NOT exceed %d bytes", maxBytesErr.Limit), nil)
+					return
+				case err != nil:
+					WriteError(w, http.StatusBadRequest, nil, CodeInvalidRequest, "failed to read the request body", nil)
+					return
+				}
+				messages, err := Parse(body)
+				if err != nil {
+					WriteError(w, http.StatusBadRequest, nil, CodeParseError, err.Error(), nil)
+					return
+				}
+				if len(messages) == 1 {
+					id = messages[0].ID
+				}
+				for _, message := range messages {
+					initialize = initialize || message.Method == "initialize"
+					g.log(c, message)
+				}
+			}
+
+			if c.session != "" {
+				s, exists := g.lookup(service, c.session, time.Now())
+				if !exists {
+					WriteError(w, http.StatusNotFound, id, CodeSessionNotFound, "session not found, initialize a new one", nil)
+					return
+				}
+				c.endpoint = s.endpoint
+			} else if initialize {
+				// the session is pinned to the pod that issues it
+				c.endpoint = g.pick(r.Context(), service)
+			}
+
+			ew := &errorWriter{ResponseWriter: w}
+			next.ServeHTTP(ew, r.WithContext(context.WithValue(r.Context(), contextKey{}, c)))
+			ew.finish(id)
+		})
+	}
+}
+
+func (g *Gateway) log(c *call, message Message) {
+	if message.Method == "" {
+		// a response of the client to the server
+		return
+	}
+	attrs := []any{"service", c.service, "method", message.Method}
+	if c.session != "" {
+		attrs = append(attrs, "session", c.session)
+	}
+	if tool := message.ToolName(); tool != "" {
+		attrs = append(attrs, "tool", tool)
+	}
+	g.logger.Info("MCP request", attrs...)
+}
+
+// ModifyResponse pins the sessions issued by the function, chain it in the proxy ModifyResponse.
+func (g *Gateway) ModifyResponse(resp *http.Response) error {
+	c, exists := resp.Request.Context().Value(contextKey{}).(*call)
+	if !exists {
+		return nil
+	}
+	switch {
+	case c.session == "":
+		if id := resp.Header.Get(HeaderSessionID); id != "" {
+			g.pin(c.service, id, c.endpoint, time.Now())
+		}
+	case resp.StatusCode == http.StatusNotFound:
+		// the function no longer knows the session, i.e. it restarted
+		g.unpin(c.service, c.session)
+	case resp.Request.Method == http.MethodDelete && resp.StatusCode < http.StatusMultipleChoices:
+		// the client terminated the session
+		g.unpin(c.service, c.session)
+	}
+	return nil
+}
+
+// OnError forgets the session whose pod cannot be reached, call it from the proxy ErrorHandler.
+func (g *Gateway) OnError(r *http.Request, err error) {
+	c, exists := r.Context().Value(contextKey{}).(*call)
+	if !exists || c.session == "" || c.endpoint == "" {
+		return
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		g.logger.Warn("Forgetting the MCP session of an unreachable pod", "service", c.service, "session", c.session, "endpoint", c.endpoint)
+		g.unpin(c.service, c.session)
+	}
+}
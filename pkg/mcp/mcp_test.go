@@ -0,0 +1,110 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"poorman-faas/pkg/proxy"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	messages, err := Parse([]byte(`[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo"}},{"jsonrpc":"2.0","method":"notifications/initialized"}]`))
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+	if len(messages) != 2 || messages[0].ToolName() != "echo" || messages[1].ToolName() != "" {
+		t.Errorf("Unexpected messages %+v", messages)
+	}
+	for _, body := range []string{`{"id":1,"method":"ping"}`, `[]`, `not json`} {
+		if _, err := Parse([]byte(body)); err == nil {
+			t.Errorf("Expected an error for %s", body)
+		}
+	}
+}
+
+// newPod is an MCP server that issues sessions named after the pod.
+func newPod(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderSessionID) == "" {
+			w.Header().Set(HeaderSessionID, name)
+		}
+		w.Header().Set("X-Pod", name)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	}))
+}
+
+func post(t *testing.T, gateway *httptest.Server, session string, body string) (*http.Response, Response) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL, strings.NewReader(body))
+	if session != "" {
+		req.Header.Set(HeaderSessionID, session)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http.DefaultClient.Do(): %v", err)
+	}
+	defer resp.Body.Close()
+	var decoded Response
+	_ = json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp, decoded
+}
+
+func TestGateway(t *testing.T) {
+	pod1, pod2 := newPod("pod-1"), newPod("pod-2")
+	defer pod1.Close()
+	defer pod2.Close()
+	endpoints := []string{strings.TrimPrefix(pod1.URL, "http://"), strings.TrimPrefix(pod2.URL, "http://")}
+	g := New(func(context.Context, string) ([]string, error) { return endpoints, nil }, slog.New(slog.DiscardHandler))
+
+	// the Service is reached unless the request is pinned to a pod
+	service, _ := url.Parse(pod1.URL)
+	rp, err := proxy.New(
+		proxy.WithRewrites(func(req *httputil.ProxyRequest) { req.SetURL(service) }, proxy.RewriteHost(Endpoint)),
+		proxy.WithModifyResponse(g.ModifyResponse),
+		proxy.WithErrorHandler(slog.New(slog.DiscardHandler), proxy.OnError(g.OnError)),
+	)
+	if err != nil {
+		t.Fatalf("proxy.New(): %v", err)
+	}
+	gateway := httptest.NewServer(g.Middleware(func(*http.Request) (string, bool) { return "service-1", true })(rp))
+	defer gateway.Close()
+
+	// sessions stick to the pod that issued them
+	initialize := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`
+	for _, pod := range []string{"pod-1", "pod-2"} {
+		resp, _ := post(t, gateway, "", initialize)
+		if session := resp.Header.Get(HeaderSessionID); session != pod {
+			t.Fatalf("Expected a session of %s, got %q", pod, session)
+		}
+		resp, _ = post(t, gateway, pod, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo"}}`)
+		if served := resp.Header.Get("X-Pod"); served != pod {
+			t.Errorf("Expected the session of %s to be served by it, got %s", pod, served)
+		}
+	}
+
+	resp, decoded := post(t, gateway, "unknown", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+	if resp.StatusCode != http.StatusNotFound || decoded.Error == nil || decoded.Error.Code != CodeSessionNotFound || string(decoded.ID) != "3" {
+		t.Errorf("Expected a session not found error to id 3, got %d %+v", resp.StatusCode, decoded)
+	}
+	resp, decoded = post(t, gateway, "", `{"method":`)
+	if resp.StatusCode != http.StatusBadRequest || decoded.Error == nil || decoded.Error.Code != CodeParseError {
+		t.Errorf("Expected a parse error, got %d %+v", resp.StatusCode, decoded)
+	}
+
+	// a pod that is gone is a JSON-RPC error, and its sessions are forgotten
+	pod2.Close()
+	resp, decoded = post(t, gateway, "pod-2", `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`)
+	if resp.Header.Get(proxy.HeaderError) == "" || decoded.Error == nil || decoded.Error.Code != CodeUnavailable || string(decoded.ID) != "4" {
+		t.Errorf("Expected an unavailable error to id 4, got %d %+v", resp.StatusCode, decoded)
+	}
+	if sessions := g.Sessions("service-1"); sessions != 1 {
+		t.Errorf("Expected 1 session left, got %d", sessions)
+	}
+}
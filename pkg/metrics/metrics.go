@@ -0,0 +1,145 @@
+// Package metrics exposes Prometheus metrics of the gateway, at /metrics.
+//
+// Functions are labelled by service and owner, as both are bounded by the number of deployed functions.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "faas"
+
+// UnknownService labels requests to a service that is not registered, so that arbitrary paths cannot blow up cardinality.
+const UnknownService = "unknown"
+
+// Registry holds all the metrics of the gateway, along with the Go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// ProxyRequestDuration observes the requests proxied to a function, by service, owner and status code.
+	ProxyRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the requests proxied to a function.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "owner", "code"})
+
+	// ProxyInFlight is the number of requests a function is serving, by service.
+	ProxyInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "in_flight",
+		Help:      "Requests a function is serving.",
+	}, []string{"service"})
+
+	// ProxyQueueDepth is the number of requests waiting for a function, by service.
+	ProxyQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "queue_depth",
+		Help:      "Requests waiting for a function that serves as many as it can.",
+	}, []string{"service"})
+
+	// ProxyRetries counts the requests sent again to a function that could not be reached, by service.
+	ProxyRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "retries_total",
+		Help:      "Requests sent again to a function that could not be reached.",
+	}, []string{"service"})
+
+	// ProxyCircuitState is the circuit of a function, by service: 0 closed, 1 half-open, 2 open.
+	ProxyCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "circuit_state",
+		Help:      "Circuit of a function: 0 closed, 1 half-open, 2 open.",
+	}, []string{"service"})
+
+	// UploadDuration observes the uploads, by outcome (success or failure).
+	// The owner is not a label, as it is user supplied and unbounded.
+	UploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "duration_seconds",
+		Help:      "Duration of the uploads, until the function is ready or failed.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60, 90, 120, 180},
+	}, []string{"outcome"})
+
+	// UploadFailures counts the failed uploads, by reason.
+	UploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "failures_total",
+		Help:      "Failed uploads, by reason.",
+	}, []string{"reason"})
+
+	// ReaperRegistered is the number of functions the reaper keeps track of.
+	ReaperRegistered = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reaper",
+		Name:      "registered",
+		Help:      "Functions currently registered with the reaper.",
+	})
+
+	// ReaperExpired counts the functions that expired after being idle.
+	ReaperExpired = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "reaper",
+		Name:      "expired_total",
+		Help:      "Functions that expired after being idle.",
+	})
+
+	// ReaperTeardownErrors counts the failures to tear down an expired function.
+	ReaperTeardownErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "reaper",
+		Name:      "teardown_errors_total",
+		Help:      "Failures to tear down an expired function.",
+	})
+
+	// ExpirerQueueSize is the number of functions in the expirer priority queue.
+	ExpirerQueueSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reaper",
+		Name:      "expirer_queue_size",
+		Help:      "Functions in the expirer priority queue.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ProxyRequestDuration,
+		ProxyInFlight,
+		ProxyQueueDepth,
+		ProxyRetries,
+		ProxyCircuitState,
+		UploadDuration,
+		UploadFailures,
+		ReaperRegistered,
+		ReaperExpired,
+		ReaperTeardownErrors,
+		ExpirerQueueSize,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ForgetService drops the series of a culled function, so that they are not exported forever.
+func ForgetService(service string) {
+	ProxyRequestDuration.DeletePartialMatch(prometheus.Labels{"service": service})
+	ProxyInFlight.DeleteLabelValues(service)
+	ProxyQueueDepth.DeleteLabelValues(service)
+	ProxyRetries.DeleteLabelValues(service)
+	ProxyCircuitState.DeleteLabelValues(service)
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// InstrumentProxy observes the requests to the wrapped proxy in [ProxyRequestDuration].
+//
+// getLabels returns the service and owner of the request, the service is [UnknownService] if not registered.
+func InstrumentProxy(getLabels func(r *http.Request) (service string, owner string)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			service, owner := getLabels(r)
+			ProxyRequestDuration.WithLabelValues(service, owner, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentProxy(t *testing.T) {
+	handler := InstrumentProxy(func(r *http.Request) (string, string) {
+		return "service-1", "alice"
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/gateway/service-1/", nil))
+
+	// looking up the expected series must not create a new one
+	_ = ProxyRequestDuration.WithLabelValues("service-1", "alice", "418")
+	if count := testutil.CollectAndCount(ProxyRequestDuration, "faas_proxy_request_duration_seconds"); count != 1 {
+		t.Errorf("Expected 1 series labelled with the status code, got %d", count)
+	}
+
+	ForgetService("service-1")
+	if count := testutil.CollectAndCount(ProxyRequestDuration, "faas_proxy_request_duration_seconds"); count != 0 {
+		t.Errorf("Expected the series of a culled function to be dropped, got %d", count)
+	}
+}
@@ -0,0 +1,193 @@
+// Package policy holds how the gateway serves a function, i.e. its rate limits.
+//
+// A Policy is declared at upload, and kept as JSON in an annotation of the function resources,
+// so that it survives a restart of the gateway.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is how the gateway serves a function, zero fields fall back to the gateway defaults.
+type Policy struct {
+	// RateLimit is shared by all callers of the function.
+	RateLimit *Rate `json:"rate_limit,omitempty"`
+	// CallerRateLimit applies to each caller of the function, identified by its credentials or IP.
+	CallerRateLimit *Rate `json:"caller_rate_limit,omitempty"`
+	// MaxInFlight is how many requests the function serves at once, the others wait in a FIFO queue.
+	// It applies to each replica of an autoscaled function.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+	// QueueSize is how many requests wait for the function, before new ones are rejected.
+	QueueSize int `json:"queue_size,omitempty"`
+	// QueueTimeout is how long a request waits for the function, before it is rejected.
+	QueueTimeout Duration `json:"queue_timeout,omitempty"`
+	// MinReplicas and MaxReplicas bound the autoscaler, the function is autoscaled only if MaxReplicas is declared.
+	MinReplicas int `json:"min_replicas,omitempty"`
+	MaxReplicas int `json:"max_replicas,omitempty"`
+	// TargetConcurrency is how many concurrent requests each replica should serve.
+	TargetConcurrency float64 `json:"target_concurrency,omitempty"`
+	// Retries is how many times a request is sent again when the function cannot be reached.
+	Retries int `json:"retries,omitempty"`
+	// Timeout is how long the function has to respond with headers, streaming the body is not bounded.
+	Timeout Duration `json:"timeout,omitempty"`
+	// MaxBodyBytes is the largest request body the function accepts.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// MCP is a function that is a streamable HTTP MCP server, its sessions are pinned to the pod that issued them.
+	MCP bool `json:"mcp,omitempty"`
+	// LoadBalancing is how requests are spread over the pods, one of round-robin, least-in-flight and consistent-hash.
+	LoadBalancing string `json:"load_balancing,omitempty"`
+	// HashHeader is the header that consistent-hash sends to the same pod.
+	HashHeader string `json:"hash_header,omitempty"`
+	// Weight is the share of the traffic of its name that the revision receives, relative to the other revisions.
+	// The stable revision receives 100 without weight, and canaries a small share, see pkg/traffic.
+	Weight *int `json:"weight,omitempty"`
+	// RolledBack is set by the gateway once the revision is rolled back, see pkg/traffic.
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// Parse decodes a Policy from its annotation, an empty string is the zero Policy.
+func Parse(annotation string) (Policy, error) {
+	var p Policy
+	if annotation == "" {
+		return p, nil
+	}
+	if err := json.Unmarshal([]byte(annotation), &p); err != nil {
+		return Policy{}, fmt.Errorf("json.Unmarshal(): %w", err)
+	}
+	return p, nil
+}
+
+// String encodes the Policy for its annotation.
+func (p Policy) String() string {
+	encoded, _ := json.Marshal(p)
+	return string(encoded)
+}
+
+// Validate checks that the declared values are usable.
+func (p Policy) Validate() error {
+	if p.MaxInFlight < 0 || p.QueueSize < 0 {
+		return fmt.Errorf("max_in_flight and queue_size MUST NOT be negative")
+	}
+	if p.Weight != nil && *p.Weight < 0 {
+		return fmt.Errorf("weight MUST NOT be negative")
+	}
+	if p.Retries < 0 || p.MaxBodyBytes < 0 {
+		return fmt.Errorf("retries and max_body_bytes MUST NOT be negative")
+	}
+	if p.MinReplicas < 0 || p.MaxReplicas < 0 || p.TargetConcurrency < 0 {
+		return fmt.Errorf("min_replicas, max_replicas and target_concurrency MUST NOT be negative")
+	}
+	switch p.LoadBalancing {
+	case "", "round-robin", "least-in-flight", "consistent-hash":
+	default:
+		return fmt.Errorf("load_balancing %q MUST be one of round-robin, least-in-flight, consistent-hash", p.LoadBalancing)
+	}
+	if p.MaxReplicas > 0 && p.MinReplicas > p.MaxReplicas {
+		return fmt.Errorf("min_replicas %d MUST NOT exceed max_replicas %d", p.MinReplicas, p.MaxReplicas)
+	}
+	return nil
+}
+
+// IsZero reports whether nothing is declared.
+func (p Policy) IsZero() bool {
+	return p == Policy{}
+}
+
+// Duration is a [time.Duration] written as a Go duration, i.e. `30s`.
+type Duration time.Duration
+
+// MarshalText implements [encoding.TextMarshaler].
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	if parsed < 0 {
+		return fmt.Errorf("duration %q MUST NOT be negative", text)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Or returns the declared duration, or the fallback if nothing is declared.
+func (d Duration) Or(fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return time.Duration(d)
+}
+
+// Rate is a number of requests per window, written as `100/1m`. The zero Rate is unlimited.
+type Rate struct {
+	Requests int
+	Window   time.Duration
+}
+
+// ParseRate parses a `{requests}/{window}` rate, where the window is a Go duration, and defaults to 1s if empty.
+func ParseRate(s string) (Rate, error) {
+	requests, window, _ := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Rate{}, fmt.Errorf("rate %q MUST start with a positive number of requests", s)
+	}
+	rate := Rate{Requests: n, Window: time.Second}
+	if window != "" {
+		// allow `10/m` as a shorthand for `10/1m`
+		if _, err := strconv.Atoi(window[:1]); err != nil {
+			window = "1" + window
+		}
+		rate.Window, err = time.ParseDuration(window)
+		if err != nil || rate.Window < time.Second {
+			return Rate{}, fmt.Errorf("rate %q MUST have a window of at least 1s", s)
+		}
+	}
+	return rate, nil
+}
+
+// IsZero reports whether the rate is unlimited.
+func (r Rate) IsZero() bool {
+	return r.Requests <= 0
+}
+
+func (r Rate) String() string {
+	if r.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", r.Requests, r.Window)
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler], an empty rate is unlimited.
+func (r *Rate) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*r = Rate{}
+		return nil
+	}
+	rate, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
+// Or returns the declared rate, or the fallback if nothing is declared.
+func (r *Rate) Or(fallback Rate) Rate {
+	if r == nil || r.IsZero() {
+		return fallback
+	}
+	return *r
+}
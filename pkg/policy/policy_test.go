@@ -0,0 +1,54 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+		err  bool
+	}{
+		{"100/1m", Rate{100, time.Minute}, false},
+		{"10/m", Rate{10, time.Minute}, false},
+		{"5", Rate{5, time.Second}, false},
+		{"0/1m", Rate{}, true},
+		{"10/1ms", Rate{}, true},
+		{"ten/1m", Rate{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, %v, expected %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestPolicyRoundTrip(t *testing.T) {
+	p, err := Parse(`{"rate_limit":"100/1m0s"}`)
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+	if got := p.RateLimit.Or(Rate{}); got != (Rate{100, time.Minute}) {
+		t.Errorf("Expected 100/1m, got %v", got)
+	}
+	if got := p.CallerRateLimit.Or(Rate{1, time.Second}); got != (Rate{1, time.Second}) {
+		t.Errorf("Expected the fallback, got %v", got)
+	}
+	again, err := Parse(p.String())
+	if err != nil || *again.RateLimit != *p.RateLimit {
+		t.Errorf("Expected %s to round trip, got %v %v", p, again, err)
+	}
+	p, err = Parse(`{"max_in_flight":1,"queue_timeout":"10s"}`)
+	if err != nil || p.MaxInFlight != 1 || p.QueueTimeout.Or(time.Minute) != 10*time.Second {
+		t.Errorf("Expected max_in_flight 1 and queue_timeout 10s, got %+v %v", p, err)
+	}
+	if _, err := Parse(`{"queue_timeout":"-1s"}`); err == nil {
+		t.Error("Expected a negative duration to be rejected")
+	}
+	if zero, _ := Parse(""); !zero.IsZero() {
+		t.Errorf("Expected the zero Policy, got %v", zero)
+	}
+}
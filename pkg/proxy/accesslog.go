@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Redacted replaces the values of redacted headers and JSON fields.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactedHeaders are headers that carry credentials.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// DefaultRedactedFields are JSON fields that usually carry credentials.
+var DefaultRedactedFields = []string{"password", "token", "access_token", "refresh_token", "secret", "api_key"}
+
+type accessLogger struct {
+	logger       *slog.Logger
+	getLabels    func(r *http.Request) (service string, owner string)
+	isColdStart  func(service string) bool
+	maxBodyBytes int
+	withHeaders  bool
+	headers      map[string]bool
+	redactor     *Redactor
+}
+
+type AccessLogOption func(a *accessLogger)
+
+// LogBodies captures up to maxBytes of the request and response bodies, disabled if 0.
+func LogBodies(maxBytes int) AccessLogOption {
+	return func(a *accessLogger) {
+		a.maxBodyBytes = maxBytes
+	}
+}
+
+// LogHeaders logs the request and response headers.
+func LogHeaders() AccessLogOption {
+	return func(a *accessLogger) {
+		a.withHeaders = true
+	}
+}
+
+// RedactHeaders replaces the values of the given headers, case insensitive, defaults to [DefaultRedactedHeaders].
+func RedactHeaders(names ...string) AccessLogOption {
+	return func(a *accessLogger) {
+		a.headers = toSet(names)
+	}
+}
+
+// RedactFields replaces the values of the given fields in JSON bodies, case insensitive, defaults to [DefaultRedactedFields].
+func RedactFields(names ...string) AccessLogOption {
+	return func(a *accessLogger) {
+		a.redactor = NewRedactor(names...)
+	}
+}
+
+// DetectColdStart flags the invocations for which isColdStart returns true, i.e. the first one after a deploy.
+//
+// It is called before the request is proxied.
+func DetectColdStart(isColdStart func(service string) bool) AccessLogOption {
+	return func(a *accessLogger) {
+		a.isColdStart = isColdStart
+	}
+}
+
+func toSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return set
+}
+
+// AccessLog logs one line per invocation once the response is sent, with the service, owner, status, latency and sizes.
+//
+// getLabels returns the service and owner of the request.
+func AccessLog(logger *slog.Logger, getLabels func(r *http.Request) (service string, owner string), opts ...AccessLogOption) func(http.Handler) http.Handler {
+	a := &accessLogger{
+		logger:      logger,
+		getLabels:   getLabels,
+		isColdStart: func(string) bool { return false },
+		headers:     toSet(DefaultRedactedHeaders),
+		redactor:    NewRedactor(DefaultRedactedFields...),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			service, owner := a.getLabels(r)
+			coldStart := a.isColdStart(service)
+
+			requestBody := &countingReader{ReadCloser: r.Body, capture: cappedBuffer{max: a.maxBodyBytes}}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = requestBody
+			}
+			responseBody := &cappedBuffer{max: a.maxBodyBytes}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			if a.maxBodyBytes > 0 {
+				ww.Tee(responseBody)
+			}
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			attrs := []any{
+				"service", service,
+				"owner", owner,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"latency_ms", time.Since(start).Milliseconds(),
+				"bytes_in", requestBody.n,
+				"bytes_out", ww.BytesWritten(),
+				"cold_start", coldStart,
+			}
+			if a.withHeaders {
+				attrs = append(attrs,
+					"request_headers", a.redactHeaders(r.Header),
+					"response_headers", a.redactHeaders(ww.Header()),
+				)
+			}
+			if a.maxBodyBytes > 0 {
+				attrs = append(attrs,
+					"request_body", a.redactBody(r.Header.Get("Content-Type"), &requestBody.capture),
+					"response_body", a.redactBody(ww.Header().Get("Content-Type"), responseBody),
+				)
+			}
+			a.logger.Info("invocation", attrs...)
+		})
+	}
+}
+
+func (a *accessLogger) redactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		if a.headers[strings.ToLower(name)] {
+			redacted[name] = Redacted
+		} else {
+			redacted[name] = strings.Join(values, ", ")
+		}
+	}
+	return redacted
+}
+
+// redactBody returns the captured body, with the redacted fields replaced if it is JSON.
+func (a *accessLogger) redactBody(contentType string, body *cappedBuffer) string {
+	if !strings.Contains(contentType, "json") {
+		return body.String()
+	}
+	return a.redactor.Body(contentType, body.Bytes(), body.truncated)
+}
+
+// Redactor replaces the values of fields in JSON bodies, case insensitive.
+type Redactor struct {
+	fields map[string]bool
+}
+
+// NewRedactor creates a Redactor of the given fields, see [DefaultRedactedFields].
+func NewRedactor(fields ...string) *Redactor {
+	return &Redactor{fields: toSet(fields)}
+}
+
+// Body returns the body with the fields replaced if it is JSON, and as is otherwise.
+//
+// A JSON body that cannot be parsed, i.e. because it was truncated, is dropped as it may leak the fields.
+func (r *Redactor) Body(contentType string, body []byte, truncated bool) string {
+	if !strings.Contains(contentType, "json") {
+		return string(body)
+	}
+	var value any
+	if truncated || json.Unmarshal(body, &value) != nil {
+		if len(body) == 0 {
+			return ""
+		}
+		return "[UNPARSABLE JSON]"
+	}
+	redacted, err := json.Marshal(r.redactValue(value))
+	if err != nil {
+		return "[UNPARSABLE JSON]"
+	}
+	return string(redacted)
+}
+
+func (r *Redactor) redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if r.fields[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = r.redactValue(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = r.redactValue(item)
+		}
+	}
+	return value
+}
+
+// cappedBuffer keeps the first max bytes written to it, and drops the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.Buffer.String() + "...[TRUNCATED]"
+	}
+	return b.Buffer.String()
+}
+
+// countingReader counts the bytes read from the request body, and captures the first ones.
+type countingReader struct {
+	io.ReadCloser
+	n       int64
+	capture cappedBuffer
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if r.capture.max > 0 {
+		_, _ = r.capture.Write(p[:n])
+	}
+	return n, err
+}
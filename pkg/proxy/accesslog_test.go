@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	getLabels := func(r *http.Request) (string, string) { return "service-1", "alice" }
+	handler := AccessLog(logger, getLabels, LogBodies(64), LogHeaders(), DetectColdStart(func(string) bool { return true }))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/gateway/echo/", strings.NewReader(`{"user":"bob","nested":{"Password":"hunter2"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry struct {
+		Status         int               `json:"status"`
+		BytesIn        int               `json:"bytes_in"`
+		BytesOut       int               `json:"bytes_out"`
+		ColdStart      bool              `json:"cold_start"`
+		RequestHeaders map[string]string `json:"request_headers"`
+		RequestBody    string            `json:"request_body"`
+		ResponseBody   string            `json:"response_body"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("json.Unmarshal(): %v", err)
+	}
+	if entry.Status != http.StatusCreated || entry.BytesIn != 46 || entry.BytesOut != 100 || !entry.ColdStart {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if entry.RequestHeaders["Authorization"] != Redacted {
+		t.Errorf("Expected Authorization to be redacted, got %q", entry.RequestHeaders["Authorization"])
+	}
+	if strings.Contains(entry.RequestBody, "hunter2") || !strings.Contains(entry.RequestBody, "bob") {
+		t.Errorf("Expected nested password to be redacted, got %s", entry.RequestBody)
+	}
+	if !strings.HasSuffix(entry.ResponseBody, "[TRUNCATED]") || len(entry.ResponseBody) != 64+len("...[TRUNCATED]") {
+		t.Errorf("Expected response body capped at 64 bytes, got %q", entry.ResponseBody)
+	}
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is whether requests reach a function.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a single request through, to probe whether the function recovered.
+	CircuitHalfOpen
+	// CircuitOpen fails requests fast, until the cooldown is over.
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenError is returned for the requests to a function whose circuit is open.
+type CircuitOpenError struct {
+	Service string
+	// RetryAfter is the rest of the cooldown.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit of %s is open for %s", e.Service, e.RetryAfter.Round(time.Second))
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+// CircuitBreaker fails fast the requests to a function after consecutive failures to reach it.
+//
+// Once the cooldown is over, the circuit half-opens and lets a single probe through:
+// it closes if the probe succeeds, and opens again otherwise.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	onChange  func(service string, state CircuitState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker creates a CircuitBreaker that opens after threshold consecutive failures, disabled if 0.
+//
+// onChange, if not nil, is called when the circuit of a function changes state.
+func NewCircuitBreaker(threshold int, cooldown time.Duration, onChange func(service string, state CircuitState)) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+// setState MUST be called with the lock held.
+func (b *CircuitBreaker) setState(service string, c *circuit, state CircuitState) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	if b.onChange != nil {
+		b.onChange(service, state)
+	}
+}
+
+// allow returns a [CircuitOpenError] if the request MUST NOT reach the function.
+func (b *CircuitBreaker) allow(service string, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, exists := b.circuits[service]
+	if !exists {
+		return nil
+	}
+	switch c.state {
+	case CircuitOpen:
+		if remaining := c.openedAt.Add(b.cooldown).Sub(now); remaining > 0 {
+			return &CircuitOpenError{Service: service, RetryAfter: remaining}
+		}
+		b.setState(service, c, CircuitHalfOpen)
+		return nil
+	case CircuitHalfOpen:
+		// a probe is in flight
+		return &CircuitOpenError{Service: service, RetryAfter: time.Second}
+	default:
+		return nil
+	}
+}
+
+// record counts the outcome of a request that was allowed.
+func (b *CircuitBreaker) record(service string, failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, exists := b.circuits[service]
+	if !exists {
+		if !failed {
+			return
+		}
+		c = &circuit{}
+		b.circuits[service] = c
+	}
+	if !failed {
+		c.failures = 0
+		b.setState(service, c, CircuitClosed)
+		return
+	}
+	c.failures++
+	if c.state == CircuitHalfOpen || c.failures >= b.threshold {
+		c.openedAt = now
+		b.setState(service, c, CircuitOpen)
+	}
+}
+
+// State returns the state of the circuit of the function.
+func (b *CircuitBreaker) State(service string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, exists := b.circuits[service]; exists {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// Forget drops the circuit of the function, i.e. once it is culled.
+func (b *CircuitBreaker) Forget(service string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.circuits, service)
+}
+
+// Transport wraps base to fail fast the requests to functions whose circuit is open.
+//
+// Failures are errors reaching the function, or 502, 503 and 504 responses. A client cancel is not a failure.
+// getService returns the function of a request.
+func (b *CircuitBreaker) Transport(base http.RoundTripper, getService func(r *http.Request) string) http.RoundTripper {
+	if b.threshold <= 0 {
+		return base
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		service := getService(req)
+		if err := b.allow(service, time.Now()); err != nil {
+			return nil, err
+		}
+		resp, err := base.RoundTrip(req)
+		switch {
+		case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+			// the function is not to blame, a half-open circuit waits for the next probe
+			b.mu.Lock()
+			if c, exists := b.circuits[service]; exists && c.state == CircuitHalfOpen {
+				c.openedAt = time.Now().Add(-b.cooldown)
+				b.setState(service, c, CircuitOpen)
+			}
+			b.mu.Unlock()
+		case err != nil:
+			b.record(service, true, time.Now())
+		default:
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				b.record(service, true, time.Now())
+			default:
+				b.record(service, false, time.Now())
+			}
+		}
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
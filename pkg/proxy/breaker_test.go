@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failing := true
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if failing {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	var states []CircuitState
+	breaker := NewCircuitBreaker(2, 20*time.Millisecond, func(service string, state CircuitState) {
+		states = append(states, state)
+	})
+	transport := breaker.Transport(base, func(*http.Request) string { return "service-1" })
+	roundTrip := func() error {
+		_, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://function/", nil))
+		return err
+	}
+
+	_ = roundTrip()
+	_ = roundTrip()
+	var circuitErr *CircuitOpenError
+	if err := roundTrip(); !errors.As(err, &circuitErr) || circuitErr.RetryAfter <= 0 {
+		t.Fatalf("Expected the circuit to open after 2 failures, got %v", err)
+	}
+
+	// the probe fails, and the circuit opens again
+	time.Sleep(30 * time.Millisecond)
+	if err := roundTrip(); errors.As(err, &circuitErr) {
+		t.Fatalf("Expected a probe once the cooldown is over, got %v", err)
+	}
+	if breaker.State("service-1") != CircuitOpen {
+		t.Errorf("Expected the failed probe to open the circuit, got %s", breaker.State("service-1"))
+	}
+
+	// the probe succeeds, and the circuit closes
+	failing = false
+	time.Sleep(30 * time.Millisecond)
+	if err := roundTrip(); err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(states) != len(want) {
+		t.Fatalf("Expected states %v, got %v", want, states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("Expected states %v, got %v", want, states)
+			break
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Concurrency is how many requests a function serves at once, and how the others wait.
+type Concurrency struct {
+	// MaxInFlight is per replica, unlimited if 0.
+	MaxInFlight int
+	// Replicas serve MaxInFlight requests each, i.e. the current replicas of an autoscaled function, defaults to 1.
+	Replicas int
+	// QueueSize requests wait in FIFO order, the next ones are rejected.
+	QueueSize int
+	// QueueTimeout is how long a request waits, before it is rejected.
+	QueueTimeout time.Duration
+}
+
+var (
+	errQueueFull    = errors.New("queue is full")
+	errQueueTimeout = errors.New("timed out in queue")
+)
+
+// gate is the in-flight requests of a function, and the queue of the ones waiting.
+type gate struct {
+	inFlight int
+	// limit is the in-flight requests allowed by the last request, it follows the replicas of the function
+	limit int
+	// waiting requests, each is a channel closed once it is handed a slot
+	queue *list.List
+}
+
+// ConcurrencyLimiter enforces the [Concurrency] of each function, and reports their load, i.e. for autoscaling.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	gates    map[string]*gate
+	onChange func(service string, inFlight int, queued int)
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter, onChange is called with the load of a function when it changes.
+func NewConcurrencyLimiter(onChange func(service string, inFlight int, queued int)) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		gates:    make(map[string]*gate),
+		onChange: onChange,
+	}
+}
+
+// Load returns how many requests the function is serving, and how many are waiting.
+func (c *ConcurrencyLimiter) Load(service string) (inFlight int, queued int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, exists := c.gates[service]; exists {
+		return g.inFlight, g.queue.Len()
+	}
+	return 0, 0
+}
+
+// Forget drops the load of the function, i.e. once it is culled.
+func (c *ConcurrencyLimiter) Forget(service string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.gates, service)
+}
+
+// changed MUST be called with the lock held.
+func (c *ConcurrencyLimiter) changed(service string, g *gate) {
+	if c.onChange != nil {
+		c.onChange(service, g.inFlight, g.queue.Len())
+	}
+}
+
+// acquire waits for a slot of the function, in FIFO order.
+func (c *ConcurrencyLimiter) acquire(ctx context.Context, service string, limits Concurrency) error {
+	c.mu.Lock()
+	g, exists := c.gates[service]
+	if !exists {
+		g = &gate{queue: list.New()}
+		c.gates[service] = g
+	}
+	g.limit = limits.MaxInFlight * max(limits.Replicas, 1)
+	// the function MAY have scaled up since the waiting requests were queued
+	admitLocked(g)
+	if g.limit <= 0 || (g.inFlight < g.limit && g.queue.Len() == 0) {
+		g.inFlight++
+		c.changed(service, g)
+		c.mu.Unlock()
+		return nil
+	}
+	if g.queue.Len() >= limits.QueueSize {
+		c.changed(service, g)
+		c.mu.Unlock()
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	element := g.queue.PushBack(ready)
+	c.changed(service, g)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(limits.QueueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-ready:
+		// handed a slot while giving up, pass it on
+		c.releaseLocked(service, g)
+	default:
+		g.queue.Remove(element)
+		c.changed(service, g)
+	}
+	return err
+}
+
+// release hands the slot to the next waiting request, if any.
+func (c *ConcurrencyLimiter) release(service string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, exists := c.gates[service]; exists {
+		c.releaseLocked(service, g)
+	}
+}
+
+func (c *ConcurrencyLimiter) releaseLocked(service string, g *gate) {
+	g.inFlight--
+	admitLocked(g)
+	c.changed(service, g)
+}
+
+// admitLocked hands the free slots of the gate to the waiting requests, in FIFO order.
+func admitLocked(g *gate) {
+	for g.queue.Len() > 0 && (g.limit <= 0 || g.inFlight < g.limit) {
+		next := g.queue.Front()
+		g.queue.Remove(next)
+		g.inFlight++
+		close(next.Value.(chan struct{}))
+	}
+}
+
+// Middleware holds the requests to a function once it serves MaxInFlight of them per replica.
+//
+// Requests are answered 503 [ErrOverloaded] when the queue is full, and [ErrQueueTimeout] after waiting QueueTimeout.
+// getLimits returns the service of the request and its Concurrency.
+func (c *ConcurrencyLimiter) Middleware(getLimits func(r *http.Request) (string, Concurrency)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			service, limits := getLimits(r)
+			if err := c.acquire(r.Context(), service, limits); err != nil {
+				switch {
+				case errors.Is(err, errQueueFull):
+					WriteError(w, http.StatusServiceUnavailable, ErrOverloaded, "function is overloaded", time.Second)
+				case errors.Is(err, errQueueTimeout):
+					WriteError(w, http.StatusServiceUnavailable, ErrQueueTimeout, "function is busy", time.Second)
+				default:
+					WriteError(w, StatusClientClosedRequest, ErrClientCanceled, "request canceled", 0)
+				}
+				return
+			}
+			defer c.release(service)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := NewConcurrencyLimiter(nil)
+	release := make(chan struct{})
+	handler := limiter.Middleware(func(r *http.Request) (string, Concurrency) {
+		return "service-1", Concurrency{MaxInFlight: 1, QueueSize: 1, QueueTimeout: time.Second}
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func() <-chan int {
+		code := make(chan int, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			code <- w.Code
+		}()
+		return code
+	}
+	waitForLoad := func(inFlight int, queued int) {
+		t.Helper()
+		for range 100 {
+			if i, q := limiter.Load("service-1"); i == inFlight && q == queued {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		i, q := limiter.Load("service-1")
+		t.Fatalf("Expected %d in flight and %d queued, got %d and %d", inFlight, queued, i, q)
+	}
+
+	first := serve()
+	waitForLoad(1, 0)
+	second := serve()
+	waitForLoad(1, 1)
+	if code := <-serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the queue is full, got %d", code)
+	}
+
+	release <- struct{}{}
+	if code := <-first; code != http.StatusNoContent {
+		t.Errorf("Expected the first request to be served, got %d", code)
+	}
+	waitForLoad(1, 0)
+	release <- struct{}{}
+	if code := <-second; code != http.StatusNoContent {
+		t.Errorf("Expected the queued request to be served, got %d", code)
+	}
+	waitForLoad(0, 0)
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	limiter := NewConcurrencyLimiter(nil)
+	limits := Concurrency{MaxInFlight: 1, QueueSize: 1, QueueTimeout: 10 * time.Millisecond}
+	if err := limiter.acquire(t.Context(), "service-1", limits); err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+	if err := limiter.acquire(t.Context(), "service-1", limits); err != errQueueTimeout {
+		t.Errorf("Expected %v, got %v", errQueueTimeout, err)
+	}
+	if inFlight, queued := limiter.Load("service-1"); inFlight != 1 || queued != 0 {
+		t.Errorf("Expected the timed out request to leave the queue, got %d in flight and %d queued", inFlight, queued)
+	}
+}
+
+func TestConcurrencyLimiterReplicas(t *testing.T) {
+	limiter := NewConcurrencyLimiter(nil)
+	limits := Concurrency{MaxInFlight: 1, QueueSize: 1, QueueTimeout: time.Second, Replicas: 1}
+	if err := limiter.acquire(t.Context(), "service-1", limits); err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+	queued := make(chan error, 1)
+	go func() {
+		queued <- limiter.acquire(t.Context(), "service-1", limits)
+	}()
+	for range 100 {
+		if _, q := limiter.Load("service-1"); q == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the autoscaler added replicas because of the queue, they serve the waiting request and the next ones
+	limits.Replicas = 3
+	if err := limiter.acquire(t.Context(), "service-1", limits); err != nil {
+		t.Fatalf("Expected a scaled up function to serve more requests at once, got %v", err)
+	}
+	if err := <-queued; err != nil {
+		t.Errorf("Expected the queued request to be served once the function scaled up, got %v", err)
+	}
+	if inFlight, queued := limiter.Load("service-1"); inFlight != 3 || queued != 0 {
+		t.Errorf("Expected 3 in flight and none queued, got %d and %d", inFlight, queued)
+	}
+
+	// once scaled down, the requests in flight finish before new ones are served
+	if err := limiter.acquire(t.Context(), "service-1", Concurrency{MaxInFlight: 1, Replicas: 1}); err != errQueueFull {
+		t.Errorf("Expected %v, got %v", errQueueFull, err)
+	}
+	limiter.release("service-1")
+	limiter.release("service-1")
+	if inFlight, _ := limiter.Load("service-1"); inFlight != 1 {
+		t.Errorf("Expected 1 in flight, got %d", inFlight)
+	}
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// HeaderError carries the [ErrorKind] of a gateway error, so that clients can act on it without parsing the body.
+const HeaderError = "X-Faas-Error"
+
+// StatusClientClosedRequest is the status of requests canceled by the client, it is never seen by the client.
+const StatusClientClosedRequest = 499
+
+// ErrorKind is why the gateway could not proxy a request to a function.
+type ErrorKind string
+
+const (
+	// ErrUnknownFunction is a function that does not exist, or was culled.
+	ErrUnknownFunction ErrorKind = "unknown_function"
+	// ErrNotReady is a function that exists but did not start yet, the request can be retried after Retry-After.
+	ErrNotReady ErrorKind = "not_ready"
+	// ErrUpstreamTimeout is a function that did not respond in time.
+	ErrUpstreamTimeout ErrorKind = "upstream_timeout"
+	// ErrClientCanceled is a request canceled by the client before the function responded.
+	ErrClientCanceled ErrorKind = "client_canceled"
+	// ErrConnectionRefused is a function that was up but refuses connections, i.e. it crashed.
+	ErrConnectionRefused ErrorKind = "connection_refused"
+	// ErrBadGateway is any other failure to reach the function.
+	ErrBadGateway ErrorKind = "bad_gateway"
+	// ErrRateLimited is a request over the rate limits of the function, it can be retried after Retry-After.
+	ErrRateLimited ErrorKind = "rate_limited"
+	// ErrOverloaded is a function serving as many requests as it can, with a full queue.
+	ErrOverloaded ErrorKind = "overloaded"
+	// ErrQueueTimeout is a request that waited too long for the function.
+	ErrQueueTimeout ErrorKind = "queue_timeout"
+	// ErrCircuitOpen is a function failing repeatedly, requests fail fast until Retry-After.
+	ErrCircuitOpen ErrorKind = "circuit_open"
+	// ErrBodyTooLarge is a request body larger than the function accepts.
+	ErrBodyTooLarge ErrorKind = "body_too_large"
+)
+
+// ErrorResponse is the JSON body of gateway errors.
+type ErrorResponse struct {
+	Code    int       `json:"code"`
+	Error   ErrorKind `json:"error"`
+	Message string    `json:"message"`
+	// RetryAfter is in seconds, same as the Retry-After header.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// WriteError writes a gateway error, with the Retry-After header if retryAfter is positive.
+func WriteError(w http.ResponseWriter, code int, kind ErrorKind, message string, retryAfter time.Duration) {
+	response := ErrorResponse{Code: code, Error: kind, Message: message}
+	if retryAfter > 0 {
+		response.RetryAfter = int(retryAfter.Round(time.Second).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderError, string(kind))
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+type errorHandler struct {
+	logger     *slog.Logger
+	retryAfter time.Duration
+	isReady    func(r *http.Request) bool
+	onError    func(r *http.Request, err error)
+}
+
+type ErrorHandlerOption func(h *errorHandler)
+
+// RetryAfter is how long clients should wait before retrying a function that is not ready, defaults to 5 seconds.
+func RetryAfter(d time.Duration) ErrorHandlerOption {
+	return func(h *errorHandler) {
+		h.retryAfter = d
+	}
+}
+
+// DetectReady tells a function that is starting from one that crashed when it refuses connections.
+//
+// isReady returns whether the function of the request responded before, all functions are ready by default.
+func DetectReady(isReady func(r *http.Request) bool) ErrorHandlerOption {
+	return func(h *errorHandler) {
+		h.isReady = isReady
+	}
+}
+
+// OnError is called with every proxy error, i.e. to record it in the invocation history.
+func OnError(fn func(r *http.Request, err error)) ErrorHandlerOption {
+	return func(h *errorHandler) {
+		h.onError = fn
+	}
+}
+
+// classify maps a proxy error to the status code and kind returned to the client.
+func (h *errorHandler) classify(r *http.Request, err error) (int, ErrorKind) {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var circuitErr *CircuitOpenError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		return StatusClientClosedRequest, ErrClientCanceled
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge, ErrBodyTooLarge
+	case errors.As(err, &circuitErr):
+		return http.StatusServiceUnavailable, ErrCircuitOpen
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, ErrUpstreamTimeout
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		// the Service of the function does not exist
+		return http.StatusNotFound, ErrUnknownFunction
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// a Service without ready endpoints refuses connections
+		if !h.isReady(r) {
+			return http.StatusServiceUnavailable, ErrNotReady
+		}
+		return http.StatusBadGateway, ErrConnectionRefused
+	default:
+		return http.StatusBadGateway, ErrBadGateway
+	}
+}
+
+func (h *errorHandler) handle(w http.ResponseWriter, r *http.Request, err error) {
+	if h.onError != nil {
+		h.onError(r, err)
+	}
+	code, kind := h.classify(r, err)
+	attrs := []any{
+		"error", err,
+		"kind", kind,
+		"url", r.URL.String(),
+		"method", r.Method,
+		"user_agent", r.UserAgent(),
+		"remote_addr", r.RemoteAddr,
+	}
+	switch kind {
+	case ErrClientCanceled, ErrBodyTooLarge:
+		h.logger.Warn("request was rejected", attrs...)
+	case ErrNotReady, ErrCircuitOpen:
+		h.logger.Info("function is not available", attrs...)
+	default:
+		h.logger.Error("proxy error occurred", attrs...)
+	}
+
+	var retryAfter time.Duration
+	message := "function could not be reached"
+	switch kind {
+	case ErrUnknownFunction:
+		message = "function does not exist"
+	case ErrNotReady:
+		message = "function is starting"
+		retryAfter = h.retryAfter
+	case ErrUpstreamTimeout:
+		message = "function did not respond in time"
+	case ErrClientCanceled:
+		message = "request canceled"
+	case ErrConnectionRefused:
+		message = "function refused the connection"
+	case ErrBodyTooLarge:
+		message = fmt.Sprintf("request body MUST NOT exceed %d bytes", maxBytesOf(err))
+	case ErrCircuitOpen:
+		message = "function is failing"
+		retryAfter = time.Second
+		var circuitErr *CircuitOpenError
+		if errors.As(err, &circuitErr) {
+			retryAfter = max(circuitErr.RetryAfter, time.Second)
+		}
+	}
+	WriteError(w, code, kind, message, retryAfter)
+}
+
+// maxBytesOf returns the limit of a [http.MaxBytesError].
+func maxBytesOf(err error) int64 {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return maxBytesErr.Limit
+	}
+	return 0
+}
+
+// RejectUnknown answers 404 [ErrUnknownFunction] before proxying, unless exists reports the function of the request.
+//
+// Otherwise, a typo resolves to a Service that does not exist, or to any other Service of the namespace.
+func RejectUnknown(exists func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !exists(r) {
+				WriteError(w, http.StatusNotFound, ErrUnknownFunction, "function does not exist", 0)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
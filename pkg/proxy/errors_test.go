@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestErrorHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		upstream   string
+		ready      bool
+		canceled   bool
+		code       int
+		kind       ErrorKind
+		retryAfter string
+	}{
+		{"not ready", closed.URL, false, false, http.StatusServiceUnavailable, ErrNotReady, "2"},
+		{"connection refused", closed.URL, true, false, http.StatusBadGateway, ErrConnectionRefused, ""},
+		{"timeout", slow.URL, true, false, http.StatusGatewayTimeout, ErrUpstreamTimeout, ""},
+		{"client canceled", slow.URL, true, true, StatusClientClosedRequest, ErrClientCanceled, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, _ := url.Parse(tt.upstream)
+			var recorded error
+			transport := ProxyTransport()
+			transport.ResponseHeaderTimeout = 50 * time.Millisecond
+			rp, err := New(
+				WithTransport(transport),
+				WithRewrites(func(req *httputil.ProxyRequest) { req.SetURL(target) }),
+				WithErrorHandler(logger,
+					RetryAfter(2*time.Second),
+					DetectReady(func(*http.Request) bool { return tt.ready }),
+					OnError(func(r *http.Request, err error) { recorded = err }),
+				),
+			)
+			if err != nil {
+				t.Fatalf("New(): %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.canceled {
+				cancel()
+			}
+			w := httptest.NewRecorder()
+			rp.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+			var body ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("json.Unmarshal(%s): %v", w.Body.String(), err)
+			}
+			if w.Code != tt.code || body.Code != tt.code || body.Error != tt.kind {
+				t.Errorf("Expected %d %s, got %d %+v", tt.code, tt.kind, w.Code, body)
+			}
+			if got := w.Header().Get(HeaderError); got != string(tt.kind) {
+				t.Errorf("Expected %s header %q, got %q", HeaderError, tt.kind, got)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tt.retryAfter, got)
+			}
+			if recorded == nil {
+				t.Error("Expected OnError to be called")
+			}
+		})
+	}
+}
+
+func TestRejectUnknown(t *testing.T) {
+	handler := RejectUnknown(func(r *http.Request) bool { return r.URL.Path == "/known" })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/known", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected a known function to be proxied, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/typo", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get(HeaderError) != string(ErrUnknownFunction) {
+		t.Errorf("Expected 404 %s, got %d %q", ErrUnknownFunction, w.Code, w.Header().Get(HeaderError))
+	}
+}
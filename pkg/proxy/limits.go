@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errUpstreamTimeout is returned when a function did not respond with headers within its timeout.
+var errUpstreamTimeout = fmt.Errorf("function did not respond in time: %w", context.DeadlineExceeded)
+
+// timeoutTransport bounds the time until a function responds with headers.
+type timeoutTransport struct {
+	base       http.RoundTripper
+	getTimeout func(r *http.Request) time.Duration
+}
+
+// TimeoutTransport fails the requests to which the function did not respond with headers within the timeout
+// returned by getTimeout, unlimited if 0.
+//
+// Once the headers arrived, the body is not bounded, so that streams can last.
+func TimeoutTransport(base http.RoundTripper, getTimeout func(r *http.Request) time.Duration) http.RoundTripper {
+	return &timeoutTransport{base: base, getTimeout: getTimeout}
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeout := t.getTimeout(req)
+	if timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(timeout, cancel)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	timedOut := !timer.Stop()
+	if err != nil {
+		cancel()
+		if timedOut && req.Context().Err() == nil {
+			return nil, errUpstreamTimeout
+		}
+		return nil, err
+	}
+	// the context of the request lives as long as its body
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels the context of the request once the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.cancel)
+	return err
+}
+
+func (b *cancelOnClose) Write(p []byte) (int, error) {
+	return writeBody(b.ReadCloser, p)
+}
+
+// LimitBody answers 413 [ErrBodyTooLarge] to requests with a body larger than the maximum returned by getMax,
+// unlimited if 0.
+//
+// Requests that declare a larger Content-Length are rejected before proxying, others once they read too much.
+func LimitBody(getMax func(r *http.Request) int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maxBytes := getMax(r)
+			if maxBytes > 0 {
+				if r.ContentLength > maxBytes {
+					WriteError(w, http.StatusRequestEntityTooLarge, ErrBodyTooLarge, fmt.Sprintf("request body MUST NOT exceed %d bytes", maxBytes), 0)
+					return
+				}
+				if r.Body != nil && r.Body != http.NoBody {
+					r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
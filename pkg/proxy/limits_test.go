@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// streaming the body is not bounded by the timeout
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: TimeoutTransport(http.DefaultTransport, func(*http.Request) time.Duration {
+		return 50 * time.Millisecond
+	})}
+
+	if _, err := client.Get(upstream.URL + "/slow"); !errors.Is(err, errUpstreamTimeout) {
+		t.Errorf("Expected %v, got %v", errUpstreamTimeout, err)
+	}
+	resp, err := client.Get(upstream.URL + "/stream")
+	if err != nil {
+		t.Fatalf("client.Get(): %v", err)
+	}
+	defer resp.Body.Close()
+	if body, err := io.ReadAll(resp.Body); err != nil || string(body) != "done" {
+		t.Errorf("Expected the stream to outlast the timeout, got %q %v", body, err)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	rp, _ := New(
+		WithRewrites(func(req *httputil.ProxyRequest) { req.SetURL(target) }),
+		WithErrorHandler(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	handler := LimitBody(func(*http.Request) int64 { return 8 })(rp)
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		code          int
+	}{
+		{"small body", "small", 5, http.StatusNoContent},
+		{"declared too large", "too large body", 14, http.StatusRequestEntityTooLarge},
+		{"streamed too large", "too large body", -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Errorf("Expected %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+			if tt.code == http.StatusRequestEntityTooLarge && w.Header().Get(HeaderError) != string(ErrBodyTooLarge) {
+				t.Errorf("Expected %s, got %q", ErrBodyTooLarge, w.Header().Get(HeaderError))
+			}
+		})
+	}
+}
@@ -2,8 +2,6 @@
 package proxy
 
 import (
-	"context"
-	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
@@ -32,6 +30,16 @@ func WithTransport(transport http.RoundTripper) Option {
 	}
 }
 
+// WithFlushInterval sets how often the ReverseProxy flushes responses to the client, immediately if negative.
+//
+// Streams, i.e. server-sent events, are always flushed immediately.
+func WithFlushInterval(d time.Duration) Option {
+	return func(rp *httputil.ReverseProxy) error {
+		rp.FlushInterval = d
+		return nil
+	}
+}
+
 // WithModifyResponse sets the modify response function for the ReverseProxy.
 func WithModifyResponse(modifyResponse func(*http.Response) error) Option {
 	return func(rp *httputil.ReverseProxy) error {
@@ -53,49 +61,22 @@ func WithRewrites(rewrites ...func(*httputil.ProxyRequest)) Option {
 	}
 }
 
-// WithErrorHandler sets the error handler for the ReverseProxy.
-func WithErrorHandler(logger *slog.Logger) Option {
-	errorHandler := func(w http.ResponseWriter, r *http.Request, err error) {
-		logger.Error("proxy error occurred",
-			"error", err,
-			"url", r.URL.String(),
-			"method", r.Method,
-			"user_agent", r.UserAgent(),
-			"remote_addr", r.RemoteAddr,
-		)
-
-		// Check if it's a context cancellation
-		if errors.Is(r.Context().Err(), context.Canceled) {
-			logger.Warn("request was canceled by client", "url", r.URL.String())
-			http.Error(w, "request canceled", http.StatusRequestTimeout)
-		} else {
-			http.Error(w, "service temporarily unavailable", http.StatusBadGateway)
-		}
+// WithErrorHandler returns structured JSON errors with the [HeaderError] header, see [ErrorResponse].
+func WithErrorHandler(logger *slog.Logger, opts ...ErrorHandlerOption) Option {
+	h := &errorHandler{
+		logger:     logger,
+		retryAfter: 5 * time.Second,
+		isReady:    func(*http.Request) bool { return true },
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
 	return func(rp *httputil.ReverseProxy) error {
-		rp.ErrorHandler = errorHandler
+		rp.ErrorHandler = h.handle
 		return nil
 	}
 }
 
-// DebugRequest dumps the request and response for debugging.
-func DebugRequest(logger *slog.Logger) func(req *httputil.ProxyRequest) {
-	return func(req *httputil.ProxyRequest) {
-		dump, err := httputil.DumpRequest(req.In, false)
-		if err != nil {
-			logger.Debug("failed to dump request", "error", err)
-			return
-		}
-		logger.Debug("incoming request", "dump", string(dump))
-		dump, err = httputil.DumpRequest(req.Out, false)
-		if err != nil {
-			logger.Debug("failed to dump request", "error", err)
-			return
-		}
-		logger.Debug("outgoing request", "dump", string(dump))
-	}
-}
-
 // ProxyTransport creates a new transport for the ReverseProxy with extended timeouts and retry capabilities.
 func ProxyTransport() *http.Transport {
 	transport := &http.Transport{
@@ -109,9 +90,9 @@ func ProxyTransport() *http.Transport {
 		IdleConnTimeout:       120 * time.Second, // Increased idle timeout
 		TLSHandshakeTimeout:   15 * time.Second,  // Increased TLS handshake timeout
 		ExpectContinueTimeout: 5 * time.Second,   // Increased expect continue timeout
-		ResponseHeaderTimeout: 60 * time.Second,  // Added response header timeout
-		DisableKeepAlives:     false,
-		DisableCompression:    false,
+		// the response header timeout is per function, see TimeoutTransport
+		DisableKeepAlives:  false,
+		DisableCompression: false,
 	}
 	return transport
 }
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"poorman-faas/pkg/policy"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+// Limit is a rate limit that applies to a request, requests with the same Key share the same counter.
+type Limit struct {
+	Key  string
+	Rate policy.Rate
+}
+
+// rateLimiter keeps one sliding window limiter per window length, as the limit itself is set per request.
+type rateLimiter struct {
+	mu       sync.Mutex
+	limiters map[time.Duration]*httprate.RateLimiter
+}
+
+func (l *rateLimiter) limiter(window time.Duration) *httprate.RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, exists := l.limiters[window]
+	if !exists {
+		limiter = httprate.NewRateLimiter(0, window, httprate.WithResponseHeaders(httprate.ResponseHeaders{
+			Limit:     "RateLimit-Limit",
+			Remaining: "RateLimit-Remaining",
+		}))
+		l.limiters[window] = limiter
+	}
+	return limiter
+}
+
+// RateLimit answers 429 [ErrRateLimited] once any of the limits returned by getLimits is exceeded, zero rates are ignored.
+//
+// The `RateLimit-Limit`, `RateLimit-Remaining` and `RateLimit-Reset` headers describe the most restrictive limit,
+// along with `Retry-After` when the request is rejected.
+// Limits are checked in order, and a request counts towards the limits checked before it is rejected,
+// so the most specific limits, i.e. per caller, come first.
+func RateLimit(getLimits func(r *http.Request) []Limit) func(http.Handler) http.Handler {
+	l := &rateLimiter{limiters: make(map[time.Duration]*httprate.RateLimiter)}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var tightest http.Header
+			tightestRemaining := -1
+			for _, limit := range getLimits(r) {
+				if limit.Rate.IsZero() {
+					continue
+				}
+				// httprate writes its headers on the response, keep them aside to only send the tightest ones
+				headers := headerRecorder{}
+				ctx := httprate.WithRequestLimit(r.Context(), limit.Rate.Requests)
+				limited := l.limiter(limit.Rate.Window).OnLimit(headers, r.WithContext(ctx), limit.Key)
+				window := limit.Rate.Window
+				reset := window - time.Since(time.Now().Truncate(window))
+				headers.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset.Round(time.Second).Seconds())))
+				if limited {
+					copyHeaders(w.Header(), headers.Header())
+					WriteError(w, http.StatusTooManyRequests, ErrRateLimited, "rate limit exceeded", max(reset, time.Second))
+					return
+				}
+				remaining, _ := strconv.Atoi(headers.Header().Get("RateLimit-Remaining"))
+				if tightestRemaining < 0 || remaining < tightestRemaining {
+					tightest, tightestRemaining = headers.Header(), remaining
+				}
+			}
+			copyHeaders(w.Header(), tightest)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func copyHeaders(dst http.Header, src http.Header) {
+	for name, values := range src {
+		dst[name] = values
+	}
+}
+
+// headerRecorder is a [http.ResponseWriter] that only keeps headers.
+type headerRecorder http.Header
+
+func (h headerRecorder) Header() http.Header {
+	return http.Header(h)
+}
+
+func (h headerRecorder) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (h headerRecorder) WriteHeader(int) {}
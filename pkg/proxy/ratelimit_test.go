@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"poorman-faas/pkg/policy"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	handler := RateLimit(func(r *http.Request) []Limit {
+		return []Limit{
+			{Key: "caller:" + r.Header.Get("X-Api-Key"), Rate: policy.Rate{Requests: 2, Window: time.Hour}},
+			{Key: "function", Rate: policy.Rate{Requests: 3, Window: time.Hour}},
+			{Key: "unlimited"},
+		}
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	call := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("alice"); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("Expected the caller limit as the tightest, got %d %v", w.Code, w.Header())
+	}
+	_ = call("alice")
+	w := call("alice")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(HeaderError) != string(ErrRateLimited) {
+		t.Fatalf("Expected the caller to be limited, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Reset") == "" {
+		t.Errorf("Expected Retry-After and RateLimit-Reset, got %v", w.Header())
+	}
+	if w := call("bob"); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Expected another caller to use the rest of the function limit, got %d %v", w.Code, w.Header())
+	}
+	if w := call("carol"); w.Code != http.StatusTooManyRequests || w.Header().Get("RateLimit-Limit") != "3" {
+		t.Errorf("Expected the function to be limited, got %d %v", w.Code, w.Header())
+	}
+}
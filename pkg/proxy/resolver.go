@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"poorman-faas/pkg/util"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Strategy is how the [Resolver] picks the pod of a request.
+type Strategy string
+
+const (
+	// RoundRobin picks the pods in turn.
+	RoundRobin Strategy = "round-robin"
+	// LeastInFlight picks the pod serving the fewest requests of the gateway.
+	LeastInFlight Strategy = "least-in-flight"
+	// ConsistentHash picks the same pod for the same value of a header, and falls back to RoundRobin without it.
+	ConsistentHash Strategy = "consistent-hash"
+)
+
+// ParseStrategy checks that s is a known Strategy.
+func ParseStrategy(s string) (Strategy, error) {
+	switch strategy := Strategy(s); strategy {
+	case RoundRobin, LeastInFlight, ConsistentHash:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("strategy %q is not one of %s, %s, %s", s, RoundRobin, LeastInFlight, ConsistentHash)
+	}
+}
+
+// Balancing is how the requests to a function are spread over its pods.
+type Balancing struct {
+	Strategy Strategy
+	// HashHeader is the header hashed by [ConsistentHash].
+	HashHeader string
+}
+
+// hashReplicas is how many points each pod has on the hash ring, so that pods share keys evenly.
+const hashReplicas = 64
+
+type ringPoint struct {
+	hash     uint32
+	endpoint string
+}
+
+type pool struct {
+	endpoints []string
+	ring      []ringPoint
+	next      int
+}
+
+// Resolver picks a ready pod for each request to a function, from the EndpointSlices of its Service.
+//
+// Requests to functions it knows nothing about go through the Service, i.e. kube-proxy.
+type Resolver struct {
+	mu       sync.Mutex
+	pools    map[string]*pool
+	inFlight map[string]int
+}
+
+// NewResolver creates an empty Resolver, see [Resolver.Watch] to fill it.
+func NewResolver() *Resolver {
+	return &Resolver{
+		pools:    make(map[string]*pool),
+		inFlight: make(map[string]int),
+	}
+}
+
+// hashOf spreads similar strings, i.e. short keys, over the whole ring with the finalizer of murmur3.
+func hashOf(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// Set replaces the ready pods `{ip}:{port}` of the function.
+func (r *Resolver) Set(service string, endpoints []string) {
+	ring := make([]ringPoint, 0, len(endpoints)*hashReplicas)
+	for _, endpoint := range endpoints {
+		for i := range hashReplicas {
+			ring = append(ring, ringPoint{hash: hashOf(endpoint + "#" + strconv.Itoa(i)), endpoint: endpoint})
+		}
+	}
+	slices.SortFunc(ring, func(a, b ringPoint) int { return cmp.Compare(a.hash, b.hash) })
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next := 0
+	if p, exists := r.pools[service]; exists {
+		next = p.next
+	}
+	r.pools[service] = &pool{endpoints: slices.Clone(endpoints), ring: ring, next: next}
+}
+
+// Forget drops the pods of the function, i.e. once it is culled.
+func (r *Resolver) Forget(service string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pools, service)
+}
+
+// Endpoints returns the ready pods of the function, or nil if unknown.
+func (r *Resolver) Endpoints(service string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, exists := r.pools[service]; exists {
+		return slices.Clone(p.endpoints)
+	}
+	return nil
+}
+
+// pick returns a ready pod for the request and counts it in flight, or false if the function has none.
+func (r *Resolver) pick(req *http.Request, service string, balancing Balancing) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, exists := r.pools[service]
+	if !exists || len(p.endpoints) == 0 {
+		return "", false
+	}
+	var endpoint string
+	key := ""
+	if balancing.Strategy == ConsistentHash && balancing.HashHeader != "" {
+		key = req.Header.Get(balancing.HashHeader)
+	}
+	switch {
+	case key != "":
+		hash := hashOf(key)
+		i, _ := slices.BinarySearchFunc(p.ring, hash, func(point ringPoint, hash uint32) int {
+			return cmp.Compare(point.hash, hash)
+		})
+		endpoint = p.ring[i%len(p.ring)].endpoint
+	case balancing.Strategy == LeastInFlight:
+		// ties are broken in turn, so that idle pods share the load
+		for i := range p.endpoints {
+			candidate := p.endpoints[(p.next+i)%len(p.endpoints)]
+			if endpoint == "" || r.inFlight[candidate] < r.inFlight[endpoint] {
+				endpoint = candidate
+			}
+		}
+		p.next = (p.next + 1) % len(p.endpoints)
+	default:
+		endpoint = p.endpoints[p.next%len(p.endpoints)]
+		p.next = (p.next + 1) % len(p.endpoints)
+	}
+	r.inFlight[endpoint]++
+	return endpoint, true
+}
+
+func (r *Resolver) release(endpoint string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inFlight[endpoint]--; r.inFlight[endpoint] <= 0 {
+		delete(r.inFlight, endpoint)
+	}
+}
+
+// InFlight returns how many requests of the gateway the pod is serving.
+func (r *Resolver) InFlight(endpoint string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.inFlight[endpoint]
+}
+
+// Transport wraps base to send each request to a pod of its function, with the Balancing returned by getBalancing.
+//
+// getBalancing returns an empty service for requests that MUST NOT be resolved, i.e. already pinned to a pod.
+// Chain it below [RetryTransport], so that each attempt picks a pod again.
+func (r *Resolver) Transport(base http.RoundTripper, getBalancing func(r *http.Request) (string, Balancing)) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		service, balancing := getBalancing(req)
+		if service == "" {
+			return base.RoundTrip(req)
+		}
+		endpoint, exists := r.pick(req, service, balancing)
+		if !exists {
+			// fall back to the DNS name of the Service
+			return base.RoundTrip(req)
+		}
+		// the URL is replaced, which a RoundTripper MUST NOT do on the request it was given
+		req = req.Clone(req.Context())
+		if req.Host == "" {
+			// the function still sees the name of its Service
+			req.Host = req.URL.Host
+		}
+		req.URL.Host = endpoint
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			r.release(endpoint)
+			return nil, err
+		}
+		resp.Body = &stopOnClose{ReadCloser: resp.Body, stop: func() { r.release(endpoint) }}
+		return resp, nil
+	})
+}
+
+// Watch keeps the Resolver up to date with the EndpointSlices of the namespace matching labelSelector,
+// until ctx is done. It returns once the EndpointSlices are listed.
+//
+// It gives up after timeout if they cannot be listed, i.e. when RBAC does not allow it, and the Resolver stays empty.
+func (r *Resolver) Watch(ctx context.Context, clientset kubernetes.Interface, namespace string, labelSelector string, timeout time.Duration) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = labelSelector
+		}),
+	)
+	informer := factory.Discovery().V1().EndpointSlices()
+	lister := informer.Lister()
+	refresh := func(obj any) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		slice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok {
+			return
+		}
+		service := slice.Labels[discoveryv1.LabelServiceName]
+		if service == "" {
+			return
+		}
+		// the endpoints of a Service are spread over all its EndpointSlices
+		endpointSlices, err := lister.EndpointSlices(namespace).List(labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: service}))
+		if err != nil || len(endpointSlices) == 0 {
+			r.Forget(service)
+			return
+		}
+		r.Set(service, util.ReadyEndpoints(endpointSlices...))
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    refresh,
+		UpdateFunc: func(_, obj any) { refresh(obj) },
+		DeleteFunc: refresh,
+	})
+	if err != nil {
+		return fmt.Errorf("informer.AddEventHandler(): %w", err)
+	}
+	stop := make(chan struct{})
+	var once sync.Once
+	halt := func() { once.Do(func() { close(stop) }) }
+	context.AfterFunc(ctx, halt)
+	factory.Start(stop)
+	syncCtx, cancelSync := context.WithTimeout(ctx, timeout)
+	defer cancelSync()
+	for informerType, synced := range factory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			// stop watching, so that the requests go through the Services
+			halt()
+			return fmt.Errorf("factory.WaitForCacheSync(%v): not synced after %s", informerType, timeout)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestResolver(t *testing.T) {
+	release := make(chan struct{})
+	newPod := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				<-release
+			}
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	pod1, pod2, service := newPod("pod-1"), newPod("pod-2"), newPod("service")
+	defer pod1.Close()
+	defer pod2.Close()
+	defer service.Close()
+	resolver := NewResolver()
+	resolver.Set("service-1", []string{strings.TrimPrefix(pod1.URL, "http://"), strings.TrimPrefix(pod2.URL, "http://")})
+	var balancing Balancing
+	client := &http.Client{Transport: resolver.Transport(http.DefaultTransport, func(r *http.Request) (string, Balancing) {
+		return r.URL.Query().Get("service"), balancing
+	})}
+	// get returns the pod that served the request
+	get := func(path string, function string, key string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, service.URL+path+"?service="+function, nil)
+		req.Header.Set("X-Key", key)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("client.Do(): %v", err)
+			return ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	balancing = Balancing{Strategy: RoundRobin}
+	var pods []string
+	for range 4 {
+		pods = append(pods, get("/", "service-1", ""))
+	}
+	if !slices.Equal(pods, []string{"pod-1", "pod-2", "pod-1", "pod-2"}) {
+		t.Errorf("Expected the pods in turn, got %v", pods)
+	}
+	if pod := get("/", "unknown", ""); pod != "service" {
+		t.Errorf("Expected an unknown function to go through its Service, got %s", pod)
+	}
+
+	balancing = Balancing{Strategy: LeastInFlight}
+	slow := make(chan string)
+	go func() { slow <- get("/slow", "service-1", "") }()
+	busy := strings.TrimPrefix(pod1.URL, "http://")
+	for resolver.InFlight(busy) == 0 && resolver.InFlight(strings.TrimPrefix(pod2.URL, "http://")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if resolver.InFlight(busy) == 0 {
+		busy = strings.TrimPrefix(pod2.URL, "http://")
+	}
+	for range 3 {
+		if pod := get("/", "service-1", ""); strings.HasSuffix(busy, pod) || pod == "" {
+			t.Errorf("Expected the idle pod, got %s", pod)
+		}
+	}
+	close(release)
+	<-slow
+	if inFlight := resolver.InFlight(busy); inFlight != 0 {
+		t.Errorf("Expected no request in flight once the response is read, got %d", inFlight)
+	}
+
+	balancing = Balancing{Strategy: ConsistentHash, HashHeader: "X-Key"}
+	seen := make(map[string]bool)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		pod := get("/", "service-1", key)
+		if again := get("/", "service-1", key); again != pod {
+			t.Errorf("Expected key %s to stick to %s, got %s", key, pod, again)
+		}
+		seen[pod] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("Expected the keys to be spread over both pods, got %v", seen)
+	}
+}
+
+func TestResolverWatch(t *testing.T) {
+	port := int32(8000)
+	clientset := fake.NewClientset()
+	resolver := NewResolver()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := resolver.Watch(ctx, clientset, "default", "poorman-faas.io/managed=true", 5*time.Second); err != nil {
+		t.Fatalf("resolver.Watch(): %v", err)
+	}
+
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{Name: "service-1-a", Namespace: "default", Labels: map[string]string{
+			discoveryv1.LabelServiceName: "service-1",
+			"poorman-faas.io/managed":    "true",
+		}},
+		Ports:     []discoveryv1.EndpointPort{{Port: &port}},
+		Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+	}
+	if _, err := clientset.DiscoveryV1().EndpointSlices("default").Create(ctx, slice, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	waitFor := func(expected []string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !slices.Equal(resolver.Endpoints("service-1"), expected) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected endpoints %v, got %v", expected, resolver.Endpoints("service-1"))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor([]string{"10.0.0.1:8000"})
+
+	if err := clientset.DiscoveryV1().EndpointSlices("default").Delete(ctx, slice.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete(): %v", err)
+	}
+	waitFor(nil)
+}
+
+func TestResolverWatchForbidden(t *testing.T) {
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("list", "endpointslices", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	err := NewResolver().Watch(context.Background(), clientset, "default", "poorman-faas.io/managed=true", 100*time.Millisecond)
+	if err == nil {
+		t.Errorf("Expected Watch to give up once it cannot list the EndpointSlices")
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Retry is how requests to a function are retried when it cannot be reached.
+type Retry struct {
+	// Attempts is the total number of attempts, retries are disabled if 1 or less.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for each next one, with jitter.
+	Backoff time.Duration
+	// MaxBodyBytes is the largest request body kept in memory to be replayed, larger requests are not retried.
+	MaxBodyBytes int64
+}
+
+// retryTransport retries requests that failed before the function responded.
+type retryTransport struct {
+	base     http.RoundTripper
+	getRetry func(r *http.Request) Retry
+	onRetry  func(r *http.Request, attempt int, err error)
+}
+
+// RetryTransport retries the requests that failed to reach the function, with the Retry returned by getRetry.
+//
+// Requests that could not connect are always retried, as the function never saw them.
+// Other failures are only retried for idempotent methods, or requests with an `Idempotency-Key` header.
+// Responses are never retried, even errors, as the function did handle the request.
+// onRetry, if not nil, is called before each retry.
+func RetryTransport(base http.RoundTripper, getRetry func(r *http.Request) Retry, onRetry func(r *http.Request, attempt int, err error)) http.RoundTripper {
+	return &retryTransport{base: base, getRetry: getRetry, onRetry: onRetry}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retry := t.getRetry(req)
+	if retry.Attempts <= 1 {
+		return t.base.RoundTrip(req)
+	}
+	// the body is replaced, which a RoundTripper MUST NOT do on the request it was given
+	req = req.Clone(req.Context())
+	replayable, err := bufferBody(req, retry.MaxBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil || attempt >= retry.Attempts || !retryable(req, err) {
+			return resp, err
+		}
+		if t.onRetry != nil {
+			t.onRetry(req, attempt, err)
+		}
+		if err := sleep(req.Context(), backoff(retry.Backoff, attempt)); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// bufferBody keeps the request body in memory so that it can be replayed, up to maxBytes.
+//
+// It reports false if the body is larger, and then restores it to be sent once.
+func bufferBody(req *http.Request, maxBytes int64) (bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true, nil
+	}
+	if req.ContentLength > maxBytes {
+		return false, nil
+	}
+	buffered, err := io.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(buffered)) > maxBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buffered), req.Body), req.Body}
+		return false, nil
+	}
+	_ = req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buffered)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true, nil
+}
+
+// retryable reports whether the request can be sent again after the error.
+func retryable(req *http.Request, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return isIdempotent(req)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// backoff doubles the base delay for each attempt, with a jitter of up to half of it.
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << (attempt - 1)
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
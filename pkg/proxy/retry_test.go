@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRetryTransport(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	resetErr := &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		failures []error
+		attempts int
+		succeed  bool
+	}{
+		{"dial failures are retried with the body", http.MethodPost, "payload", []error{dialErr, dialErr}, 3, true},
+		{"gives up after the attempts", http.MethodGet, "", []error{dialErr, dialErr, dialErr}, 3, false},
+		{"idempotent requests are retried", http.MethodGet, "", []error{resetErr}, 2, true},
+		{"other requests are not retried", http.MethodPost, "payload", []error{resetErr}, 1, false},
+		{"large bodies are not retried", http.MethodPost, strings.Repeat("x", 100), []error{dialErr}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				attempts++
+				body := ""
+				if req.Body != nil {
+					b, _ := io.ReadAll(req.Body)
+					body = string(b)
+				}
+				if body != tt.body {
+					t.Errorf("Expected body %q on attempt %d, got %q", tt.body, attempts, body)
+				}
+				if attempts <= len(tt.failures) {
+					return nil, tt.failures[attempts-1]
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			})
+			retries := 0
+			transport := RetryTransport(base,
+				func(*http.Request) Retry { return Retry{Attempts: 3, MaxBodyBytes: 10} },
+				func(*http.Request, int, error) { retries++ },
+			)
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			_, err := transport.RoundTrip(httptest.NewRequest(tt.method, "http://function/", body))
+			if (err == nil) != tt.succeed || attempts != tt.attempts || retries != tt.attempts-1 {
+				t.Errorf("Expected %d attempts and success %v, got %d attempts, %d retries and %v", tt.attempts, tt.succeed, attempts, retries, err)
+			}
+		})
+	}
+}
@@ -11,12 +11,15 @@ import (
 
 // RewriteURL rewrites request URL to lb to request URL to internal service.
 //
-// Incoming: https://{lb-ip}/api/{svc-name}/{path-suffix}
+// Incoming: https://{lb-ip}/api/{route}/{path-suffix}
 // Outgoing: http://{svc-name}.{ns}.svc.cluster.local/{path-suffix}
-func RewriteURL(pathPrefix string, namespace string, getServiceName func(*http.Request) string) func(*httputil.ProxyRequest) {
+//
+// The route is either the service name or a human-readable name, which getServiceName resolves.
+func RewriteURL(pathPrefix string, namespace string, getRoute func(*http.Request) string, getServiceName func(*http.Request) string) func(*httputil.ProxyRequest) {
 	return func(req *httputil.ProxyRequest) {
+		route := getRoute(req.In)
 		serviceName := getServiceName(req.In)
-		newPath := strings.TrimPrefix(req.In.URL.Path, fmt.Sprintf("%s/%s", pathPrefix, serviceName))
+		newPath := strings.TrimPrefix(req.In.URL.Path, fmt.Sprintf("%s/%s", pathPrefix, route))
 		newHost := util.K8SInternalDNSName(namespace, serviceName)
 
 		req.Out.URL = &url.URL{
@@ -26,3 +29,16 @@ func RewriteURL(pathPrefix string, namespace string, getServiceName func(*http.R
 		}
 	}
 }
+
+// RewriteHost sends the request to the host returned by getHost, i.e. a specific pod `{ip}:{port}`,
+// chain it after [RewriteURL]. The request is left as is if getHost returns an empty string.
+func RewriteHost(getHost func(*http.Request) string) func(*httputil.ProxyRequest) {
+	return func(req *httputil.ProxyRequest) {
+		if host := getHost(req.In); host != "" {
+			if req.Out.Host == "" {
+				req.Out.Host = req.Out.URL.Host
+			}
+			req.Out.URL.Host = host
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeepAlive returns a ModifyResponse that calls touch every interval while the response body is open,
+// so that a function is not culled while it streams events or holds a WebSocket. Disabled if interval is 0.
+//
+// Chain it in the proxy ModifyResponse, the body of an upgraded connection stays writable.
+func KeepAlive(interval time.Duration, touch func(r *http.Request)) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if interval <= 0 {
+			return nil
+		}
+		ticker := time.NewTicker(interval)
+		done := make(chan struct{})
+		go func() {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					touch(resp.Request)
+				}
+			}
+		}()
+		resp.Body = &stopOnClose{ReadCloser: resp.Body, stop: func() { close(done) }}
+		return nil
+	}
+}
+
+// stopOnClose calls stop once the response body is closed, i.e. to stop keeping the function alive.
+type stopOnClose struct {
+	io.ReadCloser
+	stop func()
+	once sync.Once
+}
+
+func (b *stopOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.stop)
+	return err
+}
+
+func (b *stopOnClose) Write(p []byte) (int, error) {
+	return writeBody(b.ReadCloser, p)
+}
+
+// writeBody forwards writes of upgraded connections, i.e. WebSockets, whose body is an [io.ReadWriteCloser].
+func writeBody(body io.ReadCloser, p []byte) (int, error) {
+	if w, ok := body.(io.Writer); ok {
+		return w.Write(p)
+	}
+	return 0, errors.New("response body is not writable")
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStreamingGateway proxies to upstream through the transports and middlewares of the gateway.
+func newStreamingGateway(t *testing.T, upstream *httptest.Server, touched *atomic.Int32) *httptest.Server {
+	t.Helper()
+	target, _ := url.Parse(upstream.URL)
+	keepAlive := KeepAlive(10*time.Millisecond, func(*http.Request) { touched.Add(1) })
+	getService := func(*http.Request) string { return "service-1" }
+	rp, err := New(
+		WithTransport(NewCircuitBreaker(5, time.Second, nil).Transport(
+			TimeoutTransport(
+				RetryTransport(ProxyTransport(), func(*http.Request) Retry { return Retry{Attempts: 2, MaxBodyBytes: 1024} }, nil),
+				func(*http.Request) time.Duration { return time.Second },
+			),
+			getService,
+		)),
+		WithRewrites(func(req *httputil.ProxyRequest) { req.SetURL(target) }),
+		WithModifyResponse(keepAlive),
+		WithFlushInterval(-1),
+	)
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	handler := AccessLog(slog.New(slog.DiscardHandler), func(*http.Request) (string, string) { return "service-1", "" })(
+		NewConcurrencyLimiter(nil).Middleware(func(*http.Request) (string, Concurrency) {
+			return "service-1", Concurrency{MaxInFlight: 1}
+		})(rp),
+	)
+	return httptest.NewServer(handler)
+}
+
+func TestServerSentEvents(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 2 {
+			_, _ = fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			<-release
+		}
+	}))
+	defer upstream.Close()
+	var touched atomic.Int32
+	gateway := newStreamingGateway(t, upstream, &touched)
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	if err != nil {
+		t.Fatalf("http.Get(): %v", err)
+	}
+	defer resp.Body.Close()
+	// the first event arrives while the function is still streaming
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || line != "data: 0\n" {
+		t.Fatalf("Expected the first event, got %q, %v", line, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if touched.Load() == 0 {
+		t.Errorf("Expected the function to be kept alive while streaming")
+	}
+	close(release)
+}
+
+func TestWebSocket(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		_ = rw.Flush()
+		// echo lines until the client closes
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = rw.WriteString(line)
+			_ = rw.Flush()
+		}
+	}))
+	defer upstream.Close()
+	var touched atomic.Int32
+	gateway := newStreamingGateway(t, upstream, &touched)
+	defer gateway.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gateway.URL, "http://"))
+	if err != nil {
+		t.Fatalf("net.Dial(): %v", err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("GET /socket HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	for _, message := range []string{"ping\n", "pong\n"} {
+		_, _ = conn.Write([]byte(message))
+		if line, err := reader.ReadString('\n'); err != nil || line != message {
+			t.Fatalf("Expected %q echoed, got %q, %v", message, line, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if touched.Load() == 0 {
+		t.Errorf("Expected the function to be kept alive while the socket is open")
+	}
+
+	// the function is no longer kept alive once the socket is closed
+	_ = conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	after := touched.Load()
+	time.Sleep(50 * time.Millisecond)
+	if touched.Load() != after {
+		t.Errorf("Expected the function not to be kept alive after the socket closed")
+	}
+}
@@ -13,6 +13,8 @@ type Expirer interface {
 	Update(ctx context.Context, uuid string) error
 	// Expire returns a list of resources that have expired.
 	Expire(ctx context.Context) []string
+	// Len returns the number of resources being tracked.
+	Len() int
 }
 
 // PQExpirer is an expirer that uses a priority queue to expire resources.
@@ -60,6 +62,13 @@ func (e *PQExpirer) Update(ctx context.Context, uuid string) error {
 	return nil
 }
 
+// Len returns the number of resources being tracked.
+func (e *PQExpirer) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.pq.Len()
+}
+
 // Expire returns a list of resources that have expired.
 // Resources are considered expired if their last access time is older than the expiration time.
 func (e *PQExpirer) Expire(ctx context.Context) []string {
@@ -7,8 +7,12 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"poorman-faas/pkg/audit"
 	"poorman-faas/pkg/helm"
+	"poorman-faas/pkg/metrics"
+	"poorman-faas/pkg/policy"
 	"poorman-faas/pkg/util"
+	"slices"
 	"sync"
 	"time"
 
@@ -17,6 +21,16 @@ import (
 
 type Charter interface {
 	Teardown(ctx context.Context) error
+	// Name returns the human-readable alias of the chart, or empty string if it has none.
+	Name() string
+	// Owner returns the user who uploaded the chart, or empty string if unknown.
+	Owner() string
+	Policy() policy.Policy
+	// SetPolicy replaces the policy of the chart, and persists it with the chart.
+	SetPolicy(ctx context.Context, p policy.Policy) error
+	DeploymentName() string
+	// CreatedAt returns when the chart was uploaded, it orders the revisions of a name.
+	CreatedAt() time.Time
 }
 
 // Reaper culls resources that have expired by monitoring the last accessed time.
@@ -26,15 +40,40 @@ type Reaper struct {
 	// mapping of UUID to Helm Chart
 	mu      sync.RWMutex
 	mapping map[string]Charter
+	// mapping of human-readable name to the UUIDs of its revisions, oldest first
+	aliases map[string][]string
+	// services invoked since they were registered
+	invoked map[string]bool
+	// services that responded since they were registered
+	responded map[string]bool
+	// called after a service is culled
+	onCull  []func(service string)
+	auditor *audit.Auditor
+}
+
+// Option configures optional parts of a Reaper.
+type Option func(p *Reaper)
+
+// WithAuditor records hydrated and reaped functions.
+func WithAuditor(auditor *audit.Auditor) Option {
+	return func(p *Reaper) {
+		p.auditor = auditor
+	}
 }
 
 // New creates a new Reaper with the given clientset and time to live.
 // It also discovers and hydrates existing charts from the k8s cluster.
-func New(ctx context.Context, pollEvery time.Duration, timeToLive time.Duration, clientset *kubernetes.Clientset, namespace string, logger *slog.Logger) *Reaper {
+func New(ctx context.Context, pollEvery time.Duration, timeToLive time.Duration, clientset *kubernetes.Clientset, namespace string, logger *slog.Logger, opts ...Option) *Reaper {
 	p := Reaper{
-		expirer: NewPQExpirer(timeToLive),
-		mapping: make(map[string]Charter),
-		logger:  logger,
+		expirer:   NewPQExpirer(timeToLive),
+		mapping:   make(map[string]Charter),
+		aliases:   make(map[string][]string),
+		invoked:   make(map[string]bool),
+		responded: make(map[string]bool),
+		logger:    logger,
+	}
+	for _, opt := range opts {
+		opt(&p)
 	}
 
 	// Hydrate the reaper from existing cluster resources
@@ -45,6 +84,9 @@ func New(ctx context.Context, pollEvery time.Duration, timeToLive time.Duration,
 	for _, disc := range discovered {
 		if disc.Error != nil {
 			logger.Warn("failed to discover chart", "error", disc.Error)
+			event := audit.Event{Actor: audit.ActorSystem, Action: audit.ActionHydrate}
+			event.Fail(disc.Error)
+			p.auditor.Record(event)
 			continue
 		}
 
@@ -53,6 +95,13 @@ func New(ctx context.Context, pollEvery time.Duration, timeToLive time.Duration,
 
 		// Register the discovered chart with the reaper
 		p.MustRegister(ctx, wrapper.ServiceName(), wrapper)
+		p.markHydrated(wrapper.ServiceName())
+		p.auditor.Record(audit.Event{
+			Actor:   audit.ActorSystem,
+			Action:  audit.ActionHydrate,
+			Service: wrapper.ServiceName(),
+			Name:    wrapper.Name(),
+		})
 		successCount++
 	}
 
@@ -89,7 +138,15 @@ func (p *Reaper) MustRegister(ctx context.Context, service string, chart Charter
 	defer p.mu.Unlock()
 	if _, exists := p.mapping[service]; !exists {
 		p.mapping[service] = chart
-		p.logger.Debug("Reaper.MustRegister", "service", service)
+		if name := chart.Name(); name != "" {
+			// charts are hydrated in no particular order, so the revisions are sorted by upload time
+			p.aliases[name] = append(p.aliases[name], service)
+			slices.SortStableFunc(p.aliases[name], func(a, b string) int {
+				return p.mapping[a].CreatedAt().Compare(p.mapping[b].CreatedAt())
+			})
+		}
+		p.logger.Debug("Reaper.MustRegister", "service", service, "name", chart.Name())
+		metrics.ReaperRegistered.Set(float64(len(p.mapping)))
 	}
 
 	err := p.expirer.Update(ctx, service)
@@ -97,6 +154,118 @@ func (p *Reaper) MustRegister(ctx context.Context, service string, chart Charter
 		p.logger.Error("Reaper.MustRegister", "error", err, "service", service)
 		return
 	}
+	metrics.ExpirerQueueSize.Set(float64(p.expirer.Len()))
+}
+
+// Resolve returns the service that the given route refers to.
+//
+// A route is either a human-readable name registered with the chart, or the service name itself.
+// A name with several revisions resolves to the oldest one, see pkg/traffic to split traffic between them.
+func (p *Reaper) Resolve(route string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if services, exists := p.aliases[route]; exists {
+		return services[0]
+	}
+	return route
+}
+
+// Revisions returns the services registered with the name, oldest first, or nil if it is not a name.
+func (p *Reaper) Revisions(name string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Clone(p.aliases[name])
+}
+
+// Lookup resolves the route like [Reaper.Resolve], and reports whether the service is registered.
+func (p *Reaper) Lookup(route string) (string, bool) {
+	service := p.Resolve(route)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, exists := p.mapping[service]
+	return service, exists
+}
+
+// Policy returns how the gateway serves the service, the zero Policy if unknown.
+func (p *Reaper) Policy(service string) policy.Policy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if chart, exists := p.mapping[service]; exists {
+		return chart.Policy()
+	}
+	return policy.Policy{}
+}
+
+// SetPolicy replaces the policy of the service, and persists it.
+func (p *Reaper) SetPolicy(ctx context.Context, service string, pol policy.Policy) error {
+	p.mu.RLock()
+	chart, exists := p.mapping[service]
+	p.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("service %s is not registered", service)
+	}
+	return chart.SetPolicy(ctx, pol)
+}
+
+// DeploymentName returns the Deployment running the service, or empty string if unknown.
+func (p *Reaper) DeploymentName(service string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if chart, exists := p.mapping[service]; exists {
+		return chart.DeploymentName()
+	}
+	return ""
+}
+
+// Services returns the registered services, in no particular order.
+func (p *Reaper) Services() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	services := make([]string, 0, len(p.mapping))
+	for service := range p.mapping {
+		services = append(services, service)
+	}
+	return services
+}
+
+// Name returns the human-readable name of the service, or empty string if it has none.
+func (p *Reaper) Name(service string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if chart, exists := p.mapping[service]; exists {
+		return chart.Name()
+	}
+	return ""
+}
+
+// Owner returns the user who uploaded the service, or empty string if unknown.
+func (p *Reaper) Owner(service string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if chart, exists := p.mapping[service]; exists {
+		return chart.Owner()
+	}
+	return ""
+}
+
+// OnCull calls fn after a service is torn down, i.e. to forget state kept about it.
+//
+// It MUST be called before serving requests, fn is called with the reaper lock held and MUST NOT call the reaper.
+func (p *Reaper) OnCull(fn func(service string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onCull = append(p.onCull, fn)
+}
+
+// FirstInvocation reports whether the service is invoked for the first time since it was registered, i.e. a cold start.
+func (p *Reaper) FirstInvocation(service string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.mapping[service]; !exists || p.invoked[service] {
+		return false
+	}
+	p.invoked[service] = true
+	return true
 }
 
 func (p *Reaper) MustUpdate(ctx context.Context, service string) {
@@ -106,6 +275,7 @@ func (p *Reaper) MustUpdate(ctx context.Context, service string) {
 		p.logger.Error("Reaper.mapping[service]", "error", fmt.Errorf("service %s not found", service), "service", service)
 		return
 	}
+	p.responded[service] = true
 	err := p.expirer.Update(ctx, service)
 	if err != nil {
 		p.logger.Error("Reaper.expirer.Update()", "error", err, "service", service)
@@ -113,6 +283,21 @@ func (p *Reaper) MustUpdate(ctx context.Context, service string) {
 	}
 }
 
+// markHydrated records that the service was serving before the gateway restarted, so it is neither starting nor cold.
+func (p *Reaper) markHydrated(service string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.invoked[service] = true
+	p.responded[service] = true
+}
+
+// Responded reports whether the service responded since it was registered, i.e. it is not starting anymore.
+func (p *Reaper) Responded(service string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.responded[service]
+}
+
 func (p *Reaper) MustCull(ctx context.Context, services []string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -123,12 +308,31 @@ func (p *Reaper) MustCull(ctx context.Context, services []string) {
 			continue
 		}
 
+		metrics.ReaperExpired.Inc()
+		event := audit.Event{Actor: audit.ActorSystem, Action: audit.ActionReap, Service: service, Name: chart.Name()}
 		err := chart.Teardown(ctx)
 		if err != nil {
+			metrics.ReaperTeardownErrors.Inc()
 			p.logger.Error("chart.Teardown()", "error", err, "service", service)
+			event.Fail(err)
+			p.auditor.Record(event)
 			continue
 		}
+		p.auditor.Record(event)
 		delete(p.mapping, service)
+		delete(p.invoked, service)
+		delete(p.responded, service)
+		if name := chart.Name(); name != "" {
+			p.aliases[name] = slices.DeleteFunc(p.aliases[name], func(s string) bool { return s == service })
+			if len(p.aliases[name]) == 0 {
+				delete(p.aliases, name)
+			}
+		}
+		for _, fn := range p.onCull {
+			fn(service)
+		}
 		p.logger.Debug("Reaper.MustCull", "service", service)
 	}
+	metrics.ReaperRegistered.Set(float64(len(p.mapping)))
+	metrics.ExpirerQueueSize.Set(float64(p.expirer.Len()))
 }
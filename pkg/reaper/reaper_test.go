@@ -0,0 +1,138 @@
+package reaper
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"poorman-faas/pkg/policy"
+	"slices"
+	"testing"
+	"time"
+)
+
+type fakeChart struct {
+	name      string
+	createdAt time.Time
+	policy    policy.Policy
+	tornDown  bool
+}
+
+func (c *fakeChart) Teardown(ctx context.Context) error {
+	c.tornDown = true
+	return nil
+}
+
+func (c *fakeChart) Name() string {
+	return c.name
+}
+
+func (c *fakeChart) Owner() string {
+	return ""
+}
+
+func (c *fakeChart) Policy() policy.Policy {
+	return c.policy
+}
+
+func (c *fakeChart) SetPolicy(ctx context.Context, p policy.Policy) error {
+	c.policy = p
+	return nil
+}
+
+func (c *fakeChart) DeploymentName() string {
+	return ""
+}
+
+func (c *fakeChart) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+func newTestReaper() *Reaper {
+	return &Reaper{
+		expirer:   NewPQExpirer(time.Minute),
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		mapping:   make(map[string]Charter),
+		aliases:   make(map[string][]string),
+		invoked:   make(map[string]bool),
+		responded: make(map[string]bool),
+	}
+}
+
+func TestReaperResolve(t *testing.T) {
+	ctx := context.Background()
+	p := newTestReaper()
+	named := &fakeChart{name: "echo"}
+	p.MustRegister(ctx, "service-1", named)
+	p.MustRegister(ctx, "service-2", &fakeChart{})
+
+	t.Run("alias resolves to service", func(t *testing.T) {
+		if got := p.Resolve("echo"); got != "service-1" {
+			t.Errorf("Expected service-1, got %s", got)
+		}
+	})
+
+	t.Run("service name resolves to itself", func(t *testing.T) {
+		if got := p.Resolve("service-2"); got != "service-2" {
+			t.Errorf("Expected service-2, got %s", got)
+		}
+	})
+
+	t.Run("lookup reports unknown routes", func(t *testing.T) {
+		if _, exists := p.Lookup("echo"); !exists {
+			t.Error("Expected echo to be registered")
+		}
+		if _, exists := p.Lookup("unknown"); exists {
+			t.Error("Expected unknown not to be registered")
+		}
+	})
+
+	t.Run("only the first invocation is a cold start", func(t *testing.T) {
+		if !p.FirstInvocation("service-2") {
+			t.Error("Expected first invocation")
+		}
+		if p.FirstInvocation("service-2") {
+			t.Error("Expected second invocation not to be first")
+		}
+	})
+
+	t.Run("a hydrated service is already warm", func(t *testing.T) {
+		p.MustRegister(ctx, "service-6", &fakeChart{})
+		p.markHydrated("service-6")
+		if p.FirstInvocation("service-6") || !p.Responded("service-6") {
+			t.Error("Expected a hydrated service neither to be cold nor starting")
+		}
+	})
+
+	t.Run("a name has revisions", func(t *testing.T) {
+		p.MustRegister(ctx, "service-3", &fakeChart{name: "echo", createdAt: time.Now()})
+		if revisions := p.Revisions("echo"); !slices.Equal(revisions, []string{"service-1", "service-3"}) {
+			t.Errorf("Expected the revisions oldest first, got %v", revisions)
+		}
+		p.MustCull(ctx, []string{"service-3"})
+		if revisions := p.Revisions("echo"); !slices.Equal(revisions, []string{"service-1"}) {
+			t.Errorf("Expected the culled revision to be removed, got %v", revisions)
+		}
+	})
+
+	t.Run("revisions are ordered by upload time", func(t *testing.T) {
+		now := time.Now()
+		p.MustRegister(ctx, "service-5", &fakeChart{name: "hello", createdAt: now})
+		p.MustRegister(ctx, "service-4", &fakeChart{name: "hello", createdAt: now.Add(-time.Minute)})
+		if revisions := p.Revisions("hello"); !slices.Equal(revisions, []string{"service-4", "service-5"}) {
+			t.Errorf("Expected the revisions oldest first, got %v", revisions)
+		}
+		if got := p.Resolve("hello"); got != "service-4" {
+			t.Errorf("Expected the oldest revision, got %s", got)
+		}
+	})
+
+	t.Run("cull removes alias", func(t *testing.T) {
+		p.MustCull(ctx, []string{"service-1"})
+		if !named.tornDown {
+			t.Error("Chart was not torn down")
+		}
+		if got := p.Resolve("echo"); got != "echo" {
+			t.Errorf("Expected alias to be removed, got %s", got)
+		}
+	})
+}
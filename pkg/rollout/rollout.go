@@ -0,0 +1,164 @@
+// Package rollout tracks the progress of deploying a Faas, so that it can be polled asynchronously.
+package rollout
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Phase is a step of a rollout.
+type Phase string
+
+const (
+	PhaseValidating Phase = "validating"
+	PhaseCreating   Phase = "creating"
+	PhaseWaiting    Phase = "waiting"
+	PhaseReady      Phase = "ready"
+	PhaseFailed     Phase = "failed"
+)
+
+// Done reports whether the phase is terminal.
+func (p Phase) Done() bool {
+	return p == PhaseReady || p == PhaseFailed
+}
+
+// Transition records when a rollout entered a phase.
+type Transition struct {
+	Phase Phase     `json:"phase"`
+	At    time.Time `json:"at"`
+}
+
+// Status is a snapshot of a rollout.
+type Status struct {
+	ID      string `json:"id"`
+	Phase   Phase  `json:"phase"`
+	Service string `json:"service,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// Details explains a failed rollout, i.e. the state of the pods.
+	Details     any          `json:"details,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// Job is a single rollout, safe for concurrent use.
+type Job struct {
+	mu     sync.RWMutex
+	status Status
+}
+
+// ID returns the identifier to poll the rollout with.
+func (j *Job) ID() string {
+	return j.status.ID
+}
+
+// SetPhase moves the rollout into a non-terminal phase.
+func (j *Job) SetPhase(phase Phase) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.transition(phase)
+}
+
+// SetService records the service being rolled out.
+func (j *Job) SetService(service string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Service = service
+}
+
+// Ready marks the rollout as succeeded, reachable at url.
+func (j *Job) Ready(url string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.URL = url
+	j.transition(PhaseReady)
+}
+
+// Fail marks the rollout as failed for the given reason.
+func (j *Job) Fail(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Reason = err.Error()
+	j.transition(PhaseFailed)
+}
+
+// SetDetails records why the rollout failed.
+func (j *Job) SetDetails(details any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Details = details
+}
+
+// Status returns a snapshot of the rollout.
+func (j *Job) Status() Status {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	status := j.status
+	status.Transitions = append([]Transition(nil), j.status.Transitions...)
+	return status
+}
+
+func (j *Job) transition(phase Phase) {
+	now := time.Now()
+	j.status.Phase = phase
+	j.status.UpdatedAt = now
+	j.status.Transitions = append(j.status.Transitions, Transition{Phase: phase, At: now})
+}
+
+// Tracker keeps rollouts around for polling.
+//
+// Finished rollouts are forgotten after the retention period.
+type Tracker struct {
+	retention time.Duration
+	mu        sync.RWMutex
+	jobs      map[string]*Job
+}
+
+// NewTracker creates a Tracker that keeps finished rollouts for the retention period.
+func NewTracker(retention time.Duration) *Tracker {
+	return &Tracker{
+		retention: retention,
+		jobs:      make(map[string]*Job),
+	}
+}
+
+// New starts tracking a rollout in the validating phase.
+func (t *Tracker) New() *Job {
+	now := time.Now()
+	job := &Job{
+		status: Status{
+			ID:          uuid.New().String(),
+			Phase:       PhaseValidating,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			Transitions: []Transition{{Phase: PhaseValidating, At: now}},
+		},
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gc(now)
+	t.jobs[job.ID()] = job
+	return job
+}
+
+// Get returns the rollout with the given ID.
+func (t *Tracker) Get(id string) (*Job, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	job, exists := t.jobs[id]
+	return job, exists
+}
+
+// gc forgets finished rollouts older than the retention period, the caller MUST hold the lock.
+func (t *Tracker) gc(now time.Time) {
+	for id, job := range t.jobs {
+		status := job.Status()
+		if status.Phase.Done() && now.Sub(status.UpdatedAt) > t.retention {
+			delete(t.jobs, id)
+		}
+	}
+}
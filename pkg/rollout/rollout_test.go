@@ -0,0 +1,56 @@
+package rollout
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJob(t *testing.T) {
+	tracker := NewTracker(time.Hour)
+	job := tracker.New()
+
+	got, exists := tracker.Get(job.ID())
+	if !exists || got != job {
+		t.Fatal("Expected job to be tracked")
+	}
+	if job.Status().Phase != PhaseValidating {
+		t.Errorf("Expected validating phase, got %s", job.Status().Phase)
+	}
+
+	job.SetPhase(PhaseCreating)
+	job.SetPhase(PhaseWaiting)
+	job.Fail(errors.New("boom"))
+
+	status := job.Status()
+	if status.Phase != PhaseFailed || status.Reason != "boom" {
+		t.Errorf("Expected failed with reason, got %s %q", status.Phase, status.Reason)
+	}
+	phases := []Phase{PhaseValidating, PhaseCreating, PhaseWaiting, PhaseFailed}
+	if len(status.Transitions) != len(phases) {
+		t.Fatalf("Expected %d transitions, got %d", len(phases), len(status.Transitions))
+	}
+	for i, phase := range phases {
+		if status.Transitions[i].Phase != phase {
+			t.Errorf("Expected transition %d to be %s, got %s", i, phase, status.Transitions[i].Phase)
+		}
+	}
+}
+
+func TestTrackerForgetsFinishedJobs(t *testing.T) {
+	tracker := NewTracker(0)
+	done := tracker.New()
+	done.Ready("https://example.com")
+	running := tracker.New()
+
+	// creating a job collects finished ones past the retention
+	time.Sleep(time.Millisecond)
+	tracker.New()
+
+	if _, exists := tracker.Get(done.ID()); exists {
+		t.Error("Expected finished job to be forgotten")
+	}
+	if _, exists := tracker.Get(running.ID()); !exists {
+		t.Error("Expected running job to be kept")
+	}
+}
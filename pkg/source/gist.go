@@ -0,0 +1,81 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"poorman-faas/pkg/helm"
+	"regexp"
+)
+
+var gistIDPattern = regexp.MustCompile(`^[0-9a-zA-Z]+$`)
+
+// gist is the subset of the github gist API response we need.
+// See https://docs.github.com/en/rest/gists/gists#get-a-gist
+type gist struct {
+	Files map[string]struct {
+		Content   string `json:"content"`
+		Truncated bool   `json:"truncated"`
+		RawURL    string `json:"raw_url"`
+	} `json:"files"`
+	History []struct {
+		Version string `json:"version"`
+	} `json:"history"`
+}
+
+// fetchGist bundles all files of the gist.
+//
+// If no entrypoint is given and the gist has a single Python file, that file is run.
+func (f *Fetcher) fetchGist(ctx context.Context, id string, entrypoint string) (Result, error) {
+	if !gistIDPattern.MatchString(id) {
+		return Result{}, fmt.Errorf("gist id %q is invalid", id)
+	}
+
+	body, err := f.get(ctx, fmt.Sprintf("%s/gists/%s", f.gistAPI, id), http.Header{
+		"Accept": {"application/vnd.github+json"},
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	var g gist
+	if err := json.Unmarshal(body, &g); err != nil {
+		return Result{}, fmt.Errorf("json.Unmarshal(): %w", err)
+	}
+
+	files := make(map[string][]byte, len(g.Files))
+	var pythonFiles []string
+	for name, file := range g.Files {
+		content := []byte(file.Content)
+		// the API truncates large files, the raw URL has them in full
+		if file.Truncated {
+			content, err = f.get(ctx, file.RawURL, nil)
+			if err != nil {
+				return Result{}, err
+			}
+		}
+		files[name] = content
+		if path.Ext(name) == ".py" {
+			pythonFiles = append(pythonFiles, name)
+		}
+	}
+	if entrypoint == "" && len(pythonFiles) == 1 {
+		entrypoint = pythonFiles[0]
+	}
+
+	bundle, err := helm.NewBundle(files, entrypoint)
+	if err != nil {
+		return Result{}, err
+	}
+
+	digest := sha256Digest(body)
+	if len(g.History) > 0 && g.History[0].Version != "" {
+		digest = g.History[0].Version
+	}
+	return Result{
+		Bundle: bundle,
+		Origin: fmt.Sprintf("gist:%s", id),
+		Digest: digest,
+	}, nil
+}
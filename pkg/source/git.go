@@ -0,0 +1,225 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"poorman-faas/pkg/helm"
+	"strings"
+	"time"
+)
+
+// maxCheckoutSize caps the disk used by a git fetch and its checkout, git objects included.
+const maxCheckoutSize = 32 * helm.MaxBundleSize
+
+// checkoutPollInterval is how often the size of a checkout is measured while git runs.
+const checkoutPollInterval = 50 * time.Millisecond
+
+// fetchGit shallow fetches a single ref of the repository, and bundles the file or directory at the path.
+func (f *Fetcher) fetchGit(ctx context.Context, spec GitSpec, entrypoint string) (Result, error) {
+	u, err := url.Parse(spec.Repo)
+	if err != nil {
+		return Result{}, fmt.Errorf("url.Parse(): %w", err)
+	}
+	scheme := "https"
+	if f.allowFile && u.Scheme == "file" {
+		scheme = "file"
+	} else if err := f.checkURL(u, scheme); err != nil {
+		return Result{}, err
+	}
+
+	ref := spec.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return Result{}, fmt.Errorf("ref %q is invalid", ref)
+	}
+	subPath := path.Clean("/" + spec.Path)[1:]
+
+	dir, err := os.MkdirTemp("", "poorman-faas-git-")
+	if err != nil {
+		return Result{}, fmt.Errorf("os.MkdirTemp(): %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	git := func(args ...string) (string, error) {
+		subcommand := args[0]
+		// git has no size limit of its own, so it is killed once the checkout grows too large
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		exceeded := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(checkoutPollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if dirSize(dir) > f.maxCheckoutSize {
+						close(exceeded)
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+		// only the scheme of the repo is allowed, and redirects are not followed, so the fetch cannot reach another host
+		args = append([]string{
+			"-C", dir,
+			"-c", "protocol.allow=never",
+			"-c", fmt.Sprintf("protocol.%s.allow=always", scheme),
+			"-c", "http.followRedirects=false",
+		}, args...)
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		select {
+		case <-exceeded:
+			return "", fmt.Errorf("git %s: repository exceeds %d bytes", subcommand, f.maxCheckoutSize)
+		default:
+		}
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w: %s", subcommand, err, strings.TrimSpace(stderr.String()))
+		}
+		// a command faster than the poll interval is measured once done
+		if dirSize(dir) > f.maxCheckoutSize {
+			return "", fmt.Errorf("git %s: repository exceeds %d bytes", subcommand, f.maxCheckoutSize)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	// without a template, no sample hooks are copied into the checkout
+	if _, err := git("init", "--quiet", "--template="); err != nil {
+		return Result{}, err
+	}
+	if _, err := git("fetch", "--quiet", "--depth=1", "--", u.String(), ref); err != nil {
+		return Result{}, err
+	}
+	if _, err := git("checkout", "--quiet", "FETCH_HEAD"); err != nil {
+		return Result{}, err
+	}
+	commit, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return Result{}, err
+	}
+
+	bundle, err := readGitPath(dir, subPath, entrypoint)
+	if err != nil {
+		return Result{}, err
+	}
+
+	origin := originOf(u)
+	if subPath != "" {
+		origin = fmt.Sprintf("%s#%s", origin, subPath)
+	}
+	return Result{
+		Bundle: bundle,
+		Origin: origin,
+		Digest: commit,
+	}, nil
+}
+
+// dirSize returns the total size of the regular files under dir, files removed meanwhile are skipped.
+func dirSize(dir string) int64 {
+	var total int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// readGitPath bundles the file or directory at subPath of the checkout.
+//
+// Symlinks are never followed, so that a repository cannot point outside of its checkout.
+func readGitPath(dir string, subPath string, entrypoint string) (helm.Bundle, error) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return helm.Bundle{}, fmt.Errorf("filepath.EvalSymlinks(): %w", err)
+	}
+	root := filepath.Join(realDir, filepath.FromSlash(subPath))
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return helm.Bundle{}, fmt.Errorf("path %q not found in repository", subPath)
+	}
+	if resolved != root {
+		return helm.Bundle{}, fmt.Errorf("path %q MUST NOT be a symlink", subPath)
+	}
+	info, err := os.Lstat(root)
+	if err != nil {
+		return helm.Bundle{}, fmt.Errorf("os.Lstat(): %w", err)
+	}
+
+	// a single script is run as is
+	if info.Mode().IsRegular() {
+		if entrypoint != "" {
+			return helm.Bundle{}, fmt.Errorf("entrypoint requires a directory")
+		}
+		if info.Size() > helm.MaxBundleSize {
+			return helm.Bundle{}, fmt.Errorf("path %q exceeds %d bytes", subPath, helm.MaxBundleSize)
+		}
+		content, err := os.ReadFile(root)
+		if err != nil {
+			return helm.Bundle{}, fmt.Errorf("os.ReadFile(): %w", err)
+		}
+		return helm.NewBundle(map[string][]byte{helm.DefaultEntrypoint: content}, "")
+	}
+
+	// a directory is bundled, skipping git metadata and anything that is not a regular file
+	files := make(map[string][]byte)
+	var total int64
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		if total > helm.MaxBundleSize {
+			return fmt.Errorf("path %q exceeds %d bytes", subPath, helm.MaxBundleSize)
+		}
+		if len(files) >= helm.MaxBundleFiles {
+			return fmt.Errorf("path %q has more than %d files", subPath, helm.MaxBundleFiles)
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	if err != nil {
+		return helm.Bundle{}, fmt.Errorf("filepath.WalkDir(): %w", err)
+	}
+	return helm.NewBundle(files, entrypoint)
+}
@@ -0,0 +1,202 @@
+// Package source fetches Python Faas code from remote locations, i.e. URLs, git repos and github gists.
+//
+// Every fetch is bounded by a host allowlist, a timeout and [helm.MaxBundleSize], git fetches by the size of their checkout too.
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"poorman-faas/pkg/helm"
+	"time"
+)
+
+// Spec describes where to fetch code from, exactly one of URL, Git or Gist MUST be set.
+type Spec struct {
+	// URL is a https:// link to a single script or a zip/tar.gz bundle.
+	URL string `json:"url"`
+	// Git is a repository checked out at a ref.
+	Git *GitSpec `json:"git"`
+	// Gist is a github gist ID.
+	Gist string `json:"gist"`
+	// Entrypoint is the path of the script to run inside the fetched files, defaults to main.py.
+	Entrypoint string `json:"entrypoint"`
+}
+
+// GitSpec points to a file or a directory inside a git repository.
+type GitSpec struct {
+	// Repo is the https:// clone URL.
+	Repo string `json:"repo"`
+	// Ref is a branch, tag or commit, defaults to HEAD.
+	Ref string `json:"ref"`
+	// Path is a script, or a directory to bundle, relative to the repository root.
+	Path string `json:"path"`
+}
+
+// Result is the fetched code along with where it came from.
+type Result struct {
+	Bundle helm.Bundle
+	// Origin describes where the code came from, i.e. the URL.
+	Origin string
+	// Digest pins the fetched content, a commit hash for git, a sha256 otherwise.
+	Digest string
+}
+
+// Fetcher fetches code from remote locations.
+type Fetcher struct {
+	allowedHosts map[string]bool
+	timeout      time.Duration
+	client       *http.Client
+	gistAPI      string
+	// maxCheckoutSize caps the disk used by git fetches.
+	maxCheckoutSize int64
+	// allowFile permits file:// git repos, which have no host to check. Only for tests.
+	allowFile bool
+}
+
+// NewFetcher creates a Fetcher that only talks to the given hosts, and gives up after timeout.
+func NewFetcher(allowedHosts []string, timeout time.Duration) *Fetcher {
+	f := &Fetcher{
+		allowedHosts:    make(map[string]bool, len(allowedHosts)),
+		timeout:         timeout,
+		gistAPI:         "https://api.github.com",
+		maxCheckoutSize: maxCheckoutSize,
+	}
+	for _, host := range allowedHosts {
+		f.allowedHosts[host] = true
+	}
+	f.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			// redirects MUST stay within the allowlist too
+			return f.checkURL(req.URL, "https")
+		},
+	}
+	return f
+}
+
+// Fetch resolves the Spec into a Bundle.
+func (f *Fetcher) Fetch(ctx context.Context, spec Spec) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	set := 0
+	for _, isSet := range []bool{spec.URL != "", spec.Git != nil, spec.Gist != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return Result{}, fmt.Errorf("exactly one of url, git or gist MUST be set")
+	}
+
+	switch {
+	case spec.URL != "":
+		return f.fetchURL(ctx, spec.URL, spec.Entrypoint)
+	case spec.Git != nil:
+		return f.fetchGit(ctx, *spec.Git, spec.Entrypoint)
+	default:
+		return f.fetchGist(ctx, spec.Gist, spec.Entrypoint)
+	}
+}
+
+// checkURL ensures the URL uses the given scheme, and its host is allowed.
+func (f *Fetcher) checkURL(u *url.URL, scheme string) error {
+	if u.Scheme != scheme {
+		return fmt.Errorf("url %q MUST use %s://", u.Redacted(), scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("url %q MUST NOT contain credentials", u.Redacted())
+	}
+	if !f.allowedHosts[u.Hostname()] {
+		return fmt.Errorf("host %q is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// get downloads at most [helm.MaxBundleSize] bytes from an allowed https URL.
+func (f *Fetcher) get(ctx context.Context, rawURL string, header http.Header) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse(): %w", err)
+	}
+	if err := f.checkURL(u, "https"); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext(): %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do(): %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", u.Redacted(), resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, helm.MaxBundleSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll(): %w", err)
+	}
+	if len(content) > helm.MaxBundleSize {
+		return nil, fmt.Errorf("GET %s: body exceeds %d bytes", u.Redacted(), helm.MaxBundleSize)
+	}
+	return content, nil
+}
+
+func (f *Fetcher) fetchURL(ctx context.Context, rawURL string, entrypoint string) (Result, error) {
+	content, err := f.get(ctx, rawURL, nil)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var bundle helm.Bundle
+	if helm.IsArchive(content) {
+		bundle, err = helm.ReadArchiveBundle(content, entrypoint)
+	} else {
+		if entrypoint != "" {
+			return Result{}, errors.New("entrypoint requires an archive")
+		}
+		bundle, err = helm.NewBundle(map[string][]byte{helm.DefaultEntrypoint: content}, "")
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Result{}, fmt.Errorf("url.Parse(): %w", err)
+	}
+	return Result{
+		Bundle: bundle,
+		Origin: originOf(u),
+		Digest: sha256Digest(content),
+	}, nil
+}
+
+// originOf returns the URL without its credentials, query string and fragment, which MAY carry tokens.
+func originOf(u *url.URL) string {
+	stripped := *u
+	stripped.User = nil
+	stripped.RawQuery, stripped.ForceQuery = "", false
+	stripped.Fragment, stripped.RawFragment = "", ""
+	return stripped.String()
+}
+
+func sha256Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
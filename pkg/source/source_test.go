@@ -0,0 +1,228 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testScript = `# /// script
+# requires-python = ">=3.12"
+# ///
+print("hello")
+`
+
+// newTestFetcher returns a Fetcher that trusts the TLS test server.
+func newTestFetcher(t *testing.T, srv *httptest.Server) *Fetcher {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(): %v", err)
+	}
+	f := NewFetcher([]string{u.Hostname()}, 10*time.Second)
+	f.client.Transport = srv.Client().Transport
+	f.gistAPI = srv.URL
+	return f
+}
+
+func TestFetchURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/main.py", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testScript))
+	})
+	mux.HandleFunc("/big.py", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("#", 1<<20)))
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	f := newTestFetcher(t, srv)
+
+	t.Run("single script", func(t *testing.T) {
+		result, err := f.Fetch(context.Background(), Spec{URL: srv.URL + "/main.py"})
+		if err != nil {
+			t.Fatalf("Fetch(): %v", err)
+		}
+		if string(result.Bundle.Files["main.py"]) != testScript {
+			t.Errorf("Unexpected content %q", result.Bundle.Files["main.py"])
+		}
+		if !strings.HasPrefix(result.Digest, "sha256:") {
+			t.Errorf("Expected sha256 digest, got %s", result.Digest)
+		}
+	})
+
+	t.Run("origin without query string", func(t *testing.T) {
+		result, err := f.Fetch(context.Background(), Spec{URL: srv.URL + "/main.py?token=secret#top"})
+		if err != nil {
+			t.Fatalf("Fetch(): %v", err)
+		}
+		if result.Origin != srv.URL+"/main.py" {
+			t.Errorf("Expected the origin without its query string, got %s", result.Origin)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		if _, err := f.Fetch(context.Background(), Spec{URL: srv.URL + "/big.py"}); err == nil {
+			t.Error("Expected size limit error")
+		}
+	})
+
+	t.Run("host not allowed", func(t *testing.T) {
+		if _, err := f.Fetch(context.Background(), Spec{URL: "https://example.com/main.py"}); err == nil {
+			t.Error("Expected allowlist error")
+		}
+	})
+
+	t.Run("plain http not allowed", func(t *testing.T) {
+		plain := strings.Replace(srv.URL, "https://", "http://", 1)
+		if _, err := f.Fetch(context.Background(), Spec{URL: plain + "/main.py"}); err == nil {
+			t.Error("Expected scheme error")
+		}
+	})
+
+	t.Run("exactly one source", func(t *testing.T) {
+		if _, err := f.Fetch(context.Background(), Spec{URL: srv.URL + "/main.py", Gist: "abc"}); err == nil {
+			t.Error("Expected mutually exclusive error")
+		}
+	})
+}
+
+func TestFetchGist(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gists/abc123", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"files": map[string]any{
+				"echo.py":    map[string]any{"content": testScript},
+				"prompt.txt": map[string]any{"content": "hi"},
+			},
+			"history": []map[string]any{{"version": "deadbeef"}},
+		})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	f := newTestFetcher(t, srv)
+
+	result, err := f.Fetch(context.Background(), Spec{Gist: "abc123"})
+	if err != nil {
+		t.Fatalf("Fetch(): %v", err)
+	}
+	if result.Bundle.Entrypoint != "echo.py" {
+		t.Errorf("Expected the only python file as entrypoint, got %s", result.Bundle.Entrypoint)
+	}
+	if result.Digest != "deadbeef" {
+		t.Errorf("Expected gist version as digest, got %s", result.Digest)
+	}
+
+	if _, err := f.Fetch(context.Background(), Spec{Gist: "../evil"}); err == nil {
+		t.Error("Expected invalid gist id error")
+	}
+}
+
+// newBareRepo creates a bare git repository with a function in the fn directory.
+func newBareRepo(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	work := t.TempDir()
+	bare := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(work, "init", "--quiet", "--initial-branch=main")
+	if err := os.MkdirAll(filepath.Join(work, "fn"), 0o755); err != nil {
+		t.Fatalf("os.MkdirAll(): %v", err)
+	}
+	for name, content := range map[string]string{"fn/app.py": testScript, "fn/helper.py": "X = 1\n"} {
+		if err := os.WriteFile(filepath.Join(work, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("os.WriteFile(): %v", err)
+		}
+	}
+	git(work, "add", ".")
+	git(work, "commit", "--quiet", "-m", "init")
+	commit := git(work, "rev-parse", "HEAD")
+	git(bare, "init", "--quiet", "--bare")
+	git(work, "push", "--quiet", bare, "main")
+	return "file://" + bare, commit
+}
+
+func TestFetchGit(t *testing.T) {
+	repo, commit := newBareRepo(t)
+	f := NewFetcher(nil, 10*time.Second)
+	f.allowFile = true
+
+	t.Run("directory", func(t *testing.T) {
+		result, err := f.Fetch(context.Background(), Spec{
+			Git:        &GitSpec{Repo: repo, Ref: "main", Path: "fn"},
+			Entrypoint: "app.py",
+		})
+		if err != nil {
+			t.Fatalf("Fetch(): %v", err)
+		}
+		if result.Digest != commit {
+			t.Errorf("Expected commit %s, got %s", commit, result.Digest)
+		}
+		if len(result.Bundle.Files) != 2 {
+			t.Errorf("Expected 2 files, got %d", len(result.Bundle.Files))
+		}
+	})
+
+	t.Run("single file", func(t *testing.T) {
+		result, err := f.Fetch(context.Background(), Spec{
+			Git: &GitSpec{Repo: repo, Ref: "main", Path: "fn/app.py"},
+		})
+		if err != nil {
+			t.Fatalf("Fetch(): %v", err)
+		}
+		if string(result.Bundle.Files["main.py"]) != testScript {
+			t.Errorf("Unexpected content %q", result.Bundle.Files["main.py"])
+		}
+	})
+
+	t.Run("path escaping the repository stays inside", func(t *testing.T) {
+		_, err := f.Fetch(context.Background(), Spec{
+			Git: &GitSpec{Repo: repo, Ref: "main", Path: "../../etc/passwd"},
+		})
+		if err == nil {
+			t.Error("Expected path not found error")
+		}
+	})
+
+	t.Run("checkout size limit", func(t *testing.T) {
+		small := NewFetcher(nil, 10*time.Second)
+		small.allowFile = true
+		small.maxCheckoutSize = 512
+		_, err := small.Fetch(context.Background(), Spec{
+			Git: &GitSpec{Repo: repo, Ref: "main", Path: "fn/app.py"},
+		})
+		if err == nil || strings.HasPrefix(err.Error(), "git init") || !strings.Contains(err.Error(), "exceeds") {
+			t.Errorf("Expected size limit error, got %v", err)
+		}
+	})
+
+	t.Run("file scheme is not allowed by default", func(t *testing.T) {
+		_, err := NewFetcher(nil, time.Second).Fetch(context.Background(), Spec{
+			Git: &GitSpec{Repo: repo, Ref: "main"},
+		})
+		if err == nil {
+			t.Error("Expected scheme error")
+		}
+	})
+}
@@ -0,0 +1,152 @@
+// Package tracing sets up OpenTelemetry, so that a request can be followed from the gateway into the function.
+//
+// Without an exporter, spans are not recorded, but W3C `traceparent` headers are still propagated to the functions.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation scope of the spans created by the gateway.
+const TracerName = "poorman-faas"
+
+type settings struct {
+	serviceName string
+	exporter    sdktrace.SpanExporter
+	sampleRatio float64
+}
+
+type Option func(s *settings) error
+
+// WithServiceName sets the service.name resource attribute.
+func WithServiceName(name string) Option {
+	return func(s *settings) error {
+		s.serviceName = name
+		return nil
+	}
+}
+
+// WithOTLP exports spans over OTLP/HTTP to the endpoint, i.e. http://otel-collector:4318/v1/traces.
+// An empty endpoint disables exporting.
+func WithOTLP(ctx context.Context, endpoint string) Option {
+	return func(s *settings) error {
+		if endpoint == "" {
+			return nil
+		}
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return fmt.Errorf("otlptracehttp.New(): %w", err)
+		}
+		s.exporter = exporter
+		return nil
+	}
+}
+
+// WithExporter exports spans with the given exporter, i.e. [tracetest.NewInMemoryExporter] in tests.
+func WithExporter(exporter sdktrace.SpanExporter) Option {
+	return func(s *settings) error {
+		s.exporter = exporter
+		return nil
+	}
+}
+
+// WithSampleRatio samples the given fraction of the traces that do not have a sampled parent.
+func WithSampleRatio(ratio float64) Option {
+	return func(s *settings) error {
+		if ratio < 0 || ratio > 1 {
+			return fmt.Errorf("sample ratio %v MUST be within [0, 1]", ratio)
+		}
+		s.sampleRatio = ratio
+		return nil
+	}
+}
+
+// Setup installs the global tracer provider and W3C propagators.
+//
+// Without an exporter, the tracer provider is a no-op. The returned function flushes pending spans on shutdown.
+func Setup(opts ...Option) (func(context.Context) error, error) {
+	s := settings{serviceName: "faas-gateway", sampleRatio: 1}
+	for _, opt := range opts {
+		if err := opt(&s); err != nil {
+			return nil, err
+		}
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if s.exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(s.exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(s.sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(s.serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Start starts a span with the global tracer, see [End].
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error if any, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware creates a server span for each request, named after the chi route pattern.
+func Middleware(next http.Handler) http.Handler {
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if pattern := routePattern(r); pattern != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(pattern))
+		}
+	})
+	// otelhttp renames the span once chi has routed the request, and set r.Pattern
+	return otelhttp.NewHandler(routed, "gateway", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		if pattern := routePattern(r); pattern != "" {
+			return r.Method + " " + pattern
+		}
+		return r.Method
+	}))
+}
+
+// routePattern returns the full chi route pattern, or empty string before routing.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
+
+// Transport creates client spans for the requests to the functions, with the DNS lookup and connection as sub-spans,
+// and injects the `traceparent` header.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base,
+		otelhttp.WithClientTrace(func(ctx context.Context) *httptrace.ClientTrace {
+			return otelhttptrace.NewClientTrace(ctx)
+		}),
+	)
+}
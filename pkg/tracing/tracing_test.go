@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// keepSpans does not reset the recorded spans on shutdown, which flushes them.
+type keepSpans struct {
+	*tracetest.InMemoryExporter
+}
+
+func (keepSpans) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestPropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := Setup(WithExporter(keepSpans{exporter}))
+	if err != nil {
+		t.Fatalf("Setup(): %v", err)
+	}
+
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.Transport = Transport(http.DefaultTransport)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Handle("/gateway/{svcName}/*", rp)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/gateway/echo/hello", nil))
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown(): %v", err)
+	}
+	spans := exporter.GetSpans()
+
+	var server *tracetest.SpanStub
+	for i := range spans {
+		if spans[i].Name == "GET /gateway/{svcName}/*" {
+			server = &spans[i]
+		}
+	}
+	if server == nil {
+		t.Fatalf("Expected a server span named after the route, got %d spans", len(spans))
+	}
+	if !strings.Contains(traceparent, server.SpanContext.TraceID().String()) {
+		t.Errorf("Expected traceparent %q to carry trace %s", traceparent, server.SpanContext.TraceID())
+	}
+	// server span, client span, and the connection sub-spans
+	if len(spans) < 2 {
+		t.Errorf("Expected a client span, got %d spans", len(spans))
+	}
+}
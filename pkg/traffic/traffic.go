@@ -0,0 +1,366 @@
+// Package traffic splits the requests to a function name between its revisions, i.e. to release a canary.
+//
+// Each revision receives a share of the traffic relative to its weight, and a canary whose error rate exceeds
+// the threshold is rolled back to a weight of 0.
+package traffic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// HeaderRevision sends a request to a specific revision of a name, i.e. to test a canary before shifting traffic.
+const HeaderRevision = "X-Faas-Revision"
+
+// DefaultWeight is the weight of the stable revision when it did not declare one.
+const DefaultWeight = 100
+
+// DefaultCanaryWeight is the weight of the canaries that did not declare one, so that they receive a small share first.
+const DefaultCanaryWeight = 5
+
+// Revision is the state of a revision of a name.
+type Revision struct {
+	Service string `json:"service"`
+	Weight  int    `json:"weight"`
+	// Percent is the share of the traffic that the revision receives.
+	Percent float64 `json:"percent"`
+	// Requests and Errors are counted over the current window.
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// RolledBack is a canary that failed too often.
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// Rollback is what made a canary roll back.
+type Rollback struct {
+	Name      string
+	Service   string
+	ErrorRate float64
+	Requests  int
+	// Err is why the rollback could not be persisted, if any.
+	Err error
+}
+
+// ErrNotPersisted is returned by [Splitter.SetWeights] when the weights are applied but could not be persisted.
+var ErrNotPersisted = errors.New("weights are applied but not persisted")
+
+// Declared is the traffic of a revision as persisted with it, it survives a restart of the gateway.
+type Declared struct {
+	// Weight is negative if the revision declared none.
+	Weight     int
+	RolledBack bool
+}
+
+type stats struct {
+	since    time.Time
+	requests int
+	errors   int
+}
+
+// Splitter picks the revision of each request to a name.
+//
+// Revisions are ordered oldest first, the oldest is the stable one and all others are canaries.
+type Splitter struct {
+	getRevisions func(name string) []string
+	getDeclared  func(service string) Declared
+	canaryWeight int
+	threshold    float64
+	minRequests  int
+	window       time.Duration
+	onRollback   func(rollback Rollback)
+	persist      func(service string, declared Declared) error
+
+	mu sync.Mutex
+	// weights set through [Splitter.SetWeights] or by rollbacks, over the declared ones
+	weights    map[string]int
+	rolledBack map[string]bool
+	stats      map[string]*stats
+}
+
+type Option func(s *Splitter)
+
+// WithRollback rolls back a canary whose error rate exceeds threshold over a window, once it served minRequests.
+// Rollbacks are disabled if threshold is 0.
+func WithRollback(threshold float64, minRequests int, window time.Duration, onRollback func(rollback Rollback)) Option {
+	return func(s *Splitter) {
+		s.threshold = threshold
+		s.minRequests = minRequests
+		s.window = window
+		s.onRollback = onRollback
+	}
+}
+
+// WithPersist saves the weights set through [Splitter.SetWeights] and by rollbacks with the revisions,
+// so that getDeclared returns them after a restart of the gateway.
+func WithPersist(persist func(service string, declared Declared) error) Option {
+	return func(s *Splitter) {
+		s.persist = persist
+	}
+}
+
+// WithCanaryWeight is the weight of the canaries that did not declare one, defaults to [DefaultCanaryWeight].
+func WithCanaryWeight(weight int) Option {
+	return func(s *Splitter) {
+		s.canaryWeight = weight
+	}
+}
+
+// New creates a Splitter.
+//
+// getRevisions returns the services of a name oldest first, and getDeclared the traffic persisted with a service.
+func New(getRevisions func(name string) []string, getDeclared func(service string) Declared, opts ...Option) *Splitter {
+	s := &Splitter{
+		getRevisions: getRevisions,
+		getDeclared:  getDeclared,
+		canaryWeight: DefaultCanaryWeight,
+		window:       time.Minute,
+		weights:      make(map[string]int),
+		rolledBack:   make(map[string]bool),
+		stats:        make(map[string]*stats),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// declared returns the traffic persisted with the revisions, read before the lock is held,
+// as getDeclared MAY lock the reaper that calls [Splitter.Forget].
+func (s *Splitter) declared(revisions []string) map[string]Declared {
+	declared := make(map[string]Declared, len(revisions))
+	for _, service := range revisions {
+		declared[service] = s.getDeclared(service)
+	}
+	return declared
+}
+
+// weightOf returns the weight of the revision at index i of revisions, it MUST be called with the lock held.
+func (s *Splitter) weightOf(revisions []string, i int, declared map[string]Declared) int {
+	service := revisions[i]
+	if weight, exists := s.weights[service]; exists {
+		return weight
+	}
+	if weight := declared[service].Weight; weight >= 0 {
+		return weight
+	}
+	if i > 0 {
+		return s.canaryWeight
+	}
+	return DefaultWeight
+}
+
+// isRolledBack MUST be called with the lock held.
+func (s *Splitter) isRolledBack(service string, declared map[string]Declared) bool {
+	if rolledBack, exists := s.rolledBack[service]; exists {
+		return rolledBack
+	}
+	return declared[service].RolledBack
+}
+
+// Pick returns the revision of the name that serves the request, or false if the name has a single revision.
+func (s *Splitter) Pick(name string, r *http.Request) (string, bool) {
+	revisions := s.getRevisions(name)
+	if len(revisions) < 2 {
+		return "", false
+	}
+	if override := r.Header.Get(HeaderRevision); override != "" {
+		if slices.Contains(revisions, override) {
+			return override, true
+		}
+	}
+	declared := s.declared(revisions)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for i := range revisions {
+		total += s.weightOf(revisions, i, declared)
+	}
+	if total <= 0 {
+		return revisions[0], true
+	}
+	n := rand.N(total)
+	for i, service := range revisions {
+		if n -= s.weightOf(revisions, i, declared); n < 0 {
+			return service, true
+		}
+	}
+	return revisions[0], true
+}
+
+// SetWeights replaces the weights of the revisions of the name, the revisions left out keep theirs.
+//
+// A revision that was rolled back receives traffic again once it is given a weight.
+// The weights are applied even if they cannot be persisted, see [ErrNotPersisted].
+func (s *Splitter) SetWeights(name string, weights map[string]int) error {
+	revisions := s.getRevisions(name)
+	for service, weight := range weights {
+		if !slices.Contains(revisions, service) {
+			return fmt.Errorf("%s is not a revision of %s", service, name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight of %s MUST NOT be negative", service)
+		}
+	}
+	if err := s.applyWeights(name, revisions, weights, s.declared(revisions)); err != nil {
+		return err
+	}
+	if s.persist == nil {
+		return nil
+	}
+	var errs []error
+	for _, service := range revisions {
+		if weight, exists := weights[service]; exists {
+			if err := s.persist(service, Declared{Weight: weight}); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", service, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrNotPersisted, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (s *Splitter) applyWeights(name string, revisions []string, weights map[string]int, declared map[string]Declared) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for i, service := range revisions {
+		weight, exists := weights[service]
+		if !exists {
+			weight = s.weightOf(revisions, i, declared)
+		}
+		total += weight
+	}
+	if total <= 0 {
+		return fmt.Errorf("at least one revision of %s MUST have a positive weight", name)
+	}
+	for service, weight := range weights {
+		s.weights[service] = weight
+		s.rolledBack[service] = false
+		delete(s.stats, service)
+	}
+	return nil
+}
+
+// Revisions returns the state of the revisions of the name, oldest first.
+func (s *Splitter) Revisions(name string, now time.Time) []Revision {
+	revisions := s.getRevisions(name)
+	declared := s.declared(revisions)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for i := range revisions {
+		total += s.weightOf(revisions, i, declared)
+	}
+	states := make([]Revision, 0, len(revisions))
+	for i, service := range revisions {
+		state := Revision{Service: service, Weight: s.weightOf(revisions, i, declared), RolledBack: s.isRolledBack(service, declared)}
+		if total > 0 {
+			state.Percent = 100 * float64(state.Weight) / float64(total)
+		}
+		if st, exists := s.stats[service]; exists && now.Sub(st.since) < s.window {
+			state.Requests, state.Errors = st.requests, st.errors
+			if st.requests > 0 {
+				state.ErrorRate = float64(st.errors) / float64(st.requests)
+			}
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Forget drops the weight and the stats of the revision, i.e. once it is culled.
+func (s *Splitter) Forget(service string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.weights, service)
+	delete(s.rolledBack, service)
+	delete(s.stats, service)
+}
+
+// record counts the outcome of a request served by a canary, and rolls it back if it fails too often.
+func (s *Splitter) record(name string, service string, failed bool, now time.Time) {
+	if s.threshold <= 0 {
+		return
+	}
+	revisions := s.getRevisions(name)
+	if len(revisions) < 2 || revisions[0] == service {
+		// the stable revision is never rolled back
+		return
+	}
+	declared := map[string]Declared{service: s.getDeclared(service)}
+	s.mu.Lock()
+	st, exists := s.stats[service]
+	if !exists || now.Sub(st.since) >= s.window {
+		st = &stats{since: now}
+		s.stats[service] = st
+	}
+	st.requests++
+	if failed {
+		st.errors++
+	}
+	var rollback *Rollback
+	errorRate := float64(st.errors) / float64(st.requests)
+	if !s.isRolledBack(service, declared) && st.requests >= s.minRequests && errorRate > s.threshold {
+		s.weights[service] = 0
+		s.rolledBack[service] = true
+		rollback = &Rollback{Name: name, Service: service, ErrorRate: errorRate, Requests: st.requests}
+	}
+	s.mu.Unlock()
+	if rollback == nil {
+		return
+	}
+	if s.persist != nil {
+		rollback.Err = s.persist(service, Declared{Weight: 0, RolledBack: true})
+	}
+	if s.onRollback != nil {
+		s.onRollback(*rollback)
+	}
+}
+
+type contextKey struct{}
+
+type picked struct {
+	service string
+}
+
+// Picked returns the revision picked for the request, or false if its route is not split.
+func Picked(r *http.Request) (string, bool) {
+	if p, exists := r.Context().Value(contextKey{}).(*picked); exists {
+		return p.service, true
+	}
+	return "", false
+}
+
+// Middleware picks the revision of the requests to names, getRoute returns the name or service of a request.
+//
+// Chain it first, so that the next middlewares and the proxy see the revision through [Picked].
+// Responses with a 5xx status count as errors of the revision, a request canceled by the client does not count.
+func (s *Splitter) Middleware(getRoute func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := getRoute(r)
+			service, split := s.Pick(name, r)
+			if !split {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), contextKey{}, &picked{service: service})))
+			if r.Context().Err() != nil {
+				return
+			}
+			s.record(name, service, ww.Status() >= http.StatusInternalServerError, time.Now())
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package traffic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSplitter(t *testing.T) {
+	revisions := map[string][]string{"hello": {"faas-1", "faas-2"}}
+	declared := map[string]int{"faas-2": 0}
+	var rollbacks []Rollback
+	persisted := make(map[string]Declared)
+	s := New(
+		func(name string) []string { return revisions[name] },
+		func(service string) Declared {
+			if weight, exists := declared[service]; exists {
+				return Declared{Weight: weight}
+			}
+			return Declared{Weight: -1}
+		},
+		WithRollback(0.5, 4, time.Hour, func(rollback Rollback) { rollbacks = append(rollbacks, rollback) }),
+		WithPersist(func(service string, d Declared) error {
+			persisted[service] = d
+			return nil
+		}),
+	)
+	pick := func(override string) string {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/hello/", nil)
+		if override != "" {
+			r.Header.Set(HeaderRevision, override)
+		}
+		service, split := s.Pick("hello", r)
+		if !split {
+			t.Fatalf("Expected hello to be split")
+		}
+		return service
+	}
+
+	for range 20 {
+		if service := pick(""); service != "faas-1" {
+			t.Errorf("Expected a canary of weight 0 to receive no traffic, got %s", service)
+		}
+	}
+	if service := pick("faas-2"); service != "faas-2" {
+		t.Errorf("Expected the header to override the weights, got %s", service)
+	}
+	if _, split := s.Pick("single", httptest.NewRequest(http.MethodGet, "/single/", nil)); split {
+		t.Errorf("Expected a name with a single revision not to be split")
+	}
+
+	if err := s.SetWeights("hello", map[string]int{"faas-3": 10}); err == nil {
+		t.Errorf("Expected an error for a service that is not a revision")
+	}
+	if err := s.SetWeights("hello", map[string]int{"faas-1": 0}); err == nil {
+		t.Errorf("Expected an error when no revision has a positive weight")
+	}
+	if err := s.SetWeights("hello", map[string]int{"faas-1": 0, "faas-2": 100}); err != nil {
+		t.Fatalf("s.SetWeights(): %v", err)
+	}
+	if service := pick(""); service != "faas-2" {
+		t.Errorf("Expected the traffic to be shifted to the canary, got %s", service)
+	}
+	if persisted["faas-1"].Weight != 0 || persisted["faas-2"].Weight != 100 {
+		t.Errorf("Expected the weights to be persisted, got %+v", persisted)
+	}
+
+	// every revision fails, the canary is rolled back once it served enough requests
+	handler := s.Middleware(func(r *http.Request) string { return "hello" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, split := Picked(r); !split {
+			t.Errorf("Expected the picked revision in the context")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	if err := s.SetWeights("hello", map[string]int{"faas-1": 50, "faas-2": 50}); err != nil {
+		t.Fatalf("s.SetWeights(): %v", err)
+	}
+	for range 4 {
+		r := httptest.NewRequest(http.MethodGet, "/hello/", nil)
+		r.Header.Set(HeaderRevision, "faas-2")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if len(rollbacks) != 1 || rollbacks[0].Service != "faas-2" || rollbacks[0].ErrorRate != 1 {
+		t.Fatalf("Expected the canary to be rolled back once, got %+v", rollbacks)
+	}
+	states := s.Revisions("hello", time.Now())
+	if states[1].Weight != 0 || !states[1].RolledBack || states[0].Percent != 100 {
+		t.Errorf("Expected the canary to receive no traffic, got %+v", states)
+	}
+	if persisted["faas-2"] != (Declared{Weight: 0, RolledBack: true}) {
+		t.Errorf("Expected the rollback to be persisted, got %+v", persisted["faas-2"])
+	}
+
+	// a restarted gateway reads the rollback back
+	restarted := New(
+		func(name string) []string { return revisions[name] },
+		func(service string) Declared { return persisted[service] },
+	)
+	if states := restarted.Revisions("hello", time.Now()); states[1].Weight != 0 || !states[1].RolledBack {
+		t.Errorf("Expected the persisted rollback to survive a restart, got %+v", states)
+	}
+
+	// the stable revision is never rolled back
+	for range 10 {
+		r := httptest.NewRequest(http.MethodGet, "/hello/", nil)
+		r.Header.Set(HeaderRevision, "faas-1")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if len(rollbacks) != 1 {
+		t.Errorf("Expected the stable revision not to be rolled back, got %+v", rollbacks)
+	}
+}
+
+func TestSplitterCanaryWeight(t *testing.T) {
+	s := New(
+		func(string) []string { return []string{"faas-1", "faas-2"} },
+		func(string) Declared { return Declared{Weight: -1} },
+		WithCanaryWeight(25),
+	)
+	states := s.Revisions("hello", time.Now())
+	if states[0].Weight != DefaultWeight || states[1].Weight != 25 || states[1].Percent != 20 {
+		t.Errorf("Expected a canary that declares no weight to receive a small share, got %+v", states)
+	}
+}
@@ -0,0 +1,227 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultLogTailLines is how many lines of container logs a Diagnosis keeps.
+const DefaultLogTailLines = 50
+
+// ReasonStartupProbeFailed is reported when the startup probe of a container keeps failing.
+const ReasonStartupProbeFailed = "StartupProbeFailed"
+
+// fatalReasons are container waiting reasons that will not resolve by waiting longer.
+var fatalReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// Diagnosis explains why the pods behind a Deployment are not ready.
+type Diagnosis struct {
+	Deployment string `json:"deployment"`
+	// Reasons summarizes the problems found, i.e. CrashLoopBackOff, OOMKilled.
+	Reasons []string         `json:"reasons"`
+	Pods    []PodDiagnosis   `json:"pods"`
+	Events  []EventDiagnosis `json:"events"`
+}
+
+// PodDiagnosis is the state of a single pod.
+type PodDiagnosis struct {
+	Name       string               `json:"name"`
+	Phase      string               `json:"phase"`
+	Containers []ContainerDiagnosis `json:"containers"`
+}
+
+// ContainerDiagnosis is the state of a single container, along with its last log lines.
+type ContainerDiagnosis struct {
+	Name         string `json:"name"`
+	Ready        bool   `json:"ready"`
+	RestartCount int32  `json:"restart_count"`
+	// State is one of waiting, running or terminated.
+	State   string `json:"state"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// LastTermination is the reason the previous container instance exited, i.e. OOMKilled.
+	LastTermination string   `json:"last_termination,omitempty"`
+	ExitCode        *int32   `json:"exit_code,omitempty"`
+	Logs            []string `json:"logs,omitempty"`
+}
+
+// EventDiagnosis is a Kubernetes Event related to the Deployment or its pods.
+type EventDiagnosis struct {
+	Object  string `json:"object"`
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Count   int32  `json:"count"`
+}
+
+// Fatal returns the first reason that will not resolve by waiting longer.
+func (d *Diagnosis) Fatal() (string, bool) {
+	for _, reason := range d.Reasons {
+		if fatalReasons[reason] {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// DiagnoseDeployment inspects the pods behind the Deployment.
+//
+// With tailLines > 0, it also collects the container logs and the related Events, which are more expensive to fetch.
+// Logs come from the previous container instance when it has restarted, as that is the one which failed.
+func DiagnoseDeployment(ctx context.Context, clientset kubernetes.Interface, namespace string, deploymentName string, tailLines int64) (*Diagnosis, error) {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("deploymentClient.Get(%s): %w", deploymentName, err)
+	}
+	if deployment.Spec.Selector == nil {
+		return nil, fmt.Errorf("deployment %s has no selector", deploymentName)
+	}
+
+	podClient := clientset.CoreV1().Pods(namespace)
+	pods, err := podClient.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("podClient.List(): %w", err)
+	}
+
+	diagnosis := &Diagnosis{
+		Deployment: deploymentName,
+		Reasons:    []string{},
+		Pods:       []PodDiagnosis{},
+		Events:     []EventDiagnosis{},
+	}
+	reasons := make(map[string]bool)
+	for _, pod := range pods.Items {
+		podDiagnosis := PodDiagnosis{
+			Name:  pod.Name,
+			Phase: string(pod.Status.Phase),
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			container := diagnoseContainer(status)
+			for _, reason := range []string{container.Reason, container.LastTermination} {
+				if reason != "" && reason != "Completed" {
+					reasons[reason] = true
+				}
+			}
+			if tailLines > 0 {
+				container.Logs = tailLogs(ctx, clientset, namespace, pod.Name, status, tailLines)
+			}
+			podDiagnosis.Containers = append(podDiagnosis.Containers, container)
+		}
+		diagnosis.Pods = append(diagnosis.Pods, podDiagnosis)
+
+		if tailLines > 0 {
+			events, err := listEvents(ctx, clientset, namespace, pod.Name)
+			if err != nil {
+				return nil, err
+			}
+			for _, event := range events {
+				if event.Reason == "Unhealthy" && strings.HasPrefix(event.Message, "Startup probe failed") {
+					reasons[ReasonStartupProbeFailed] = true
+				}
+			}
+			diagnosis.Events = append(diagnosis.Events, events...)
+		}
+	}
+
+	if tailLines > 0 {
+		events, err := listEvents(ctx, clientset, namespace, deploymentName)
+		if err != nil {
+			return nil, err
+		}
+		diagnosis.Events = append(diagnosis.Events, events...)
+	}
+
+	for reason := range reasons {
+		diagnosis.Reasons = append(diagnosis.Reasons, reason)
+	}
+	sort.Strings(diagnosis.Reasons)
+	return diagnosis, nil
+}
+
+func diagnoseContainer(status apiv1.ContainerStatus) ContainerDiagnosis {
+	container := ContainerDiagnosis{
+		Name:         status.Name,
+		Ready:        status.Ready,
+		RestartCount: status.RestartCount,
+	}
+	switch {
+	case status.State.Waiting != nil:
+		container.State = "waiting"
+		container.Reason = status.State.Waiting.Reason
+		container.Message = status.State.Waiting.Message
+	case status.State.Terminated != nil:
+		container.State = "terminated"
+		container.Reason = status.State.Terminated.Reason
+		container.Message = status.State.Terminated.Message
+		container.ExitCode = &status.State.Terminated.ExitCode
+	case status.State.Running != nil:
+		container.State = "running"
+	}
+	if last := status.LastTerminationState.Terminated; last != nil {
+		container.LastTermination = last.Reason
+		if container.ExitCode == nil {
+			container.ExitCode = &last.ExitCode
+		}
+	}
+	return container
+}
+
+// tailLogs returns the last lines of the container logs, or the error fetching them as a single line.
+func tailLogs(ctx context.Context, clientset kubernetes.Interface, namespace string, podName string, status apiv1.ContainerStatus, tailLines int64) []string {
+	get := func(previous bool) ([]byte, error) {
+		return clientset.CoreV1().Pods(namespace).GetLogs(podName, &apiv1.PodLogOptions{
+			Container: status.Name,
+			TailLines: &tailLines,
+			Previous:  previous,
+		}).DoRaw(ctx)
+	}
+	raw, err := get(status.LastTerminationState.Terminated != nil)
+	if err != nil && status.LastTerminationState.Terminated != nil {
+		// the previous instance may be gone already
+		raw, err = get(false)
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("failed to fetch logs: %v", err)}
+	}
+	logs := strings.TrimRight(string(raw), "\n")
+	if logs == "" {
+		return nil
+	}
+	return strings.Split(logs, "\n")
+}
+
+func listEvents(ctx context.Context, clientset kubernetes.Interface, namespace string, objectName string) ([]EventDiagnosis, error) {
+	events, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.name", objectName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("eventClient.List(%s): %w", objectName, err)
+	}
+	result := make([]EventDiagnosis, 0, len(events.Items))
+	for _, event := range events.Items {
+		result = append(result, EventDiagnosis{
+			Object:  fmt.Sprintf("%s/%s", strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name),
+			Type:    event.Type,
+			Reason:  event.Reason,
+			Message: event.Message,
+			Count:   event.Count,
+		})
+	}
+	return result, nil
+}
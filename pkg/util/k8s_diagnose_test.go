@@ -0,0 +1,135 @@
+package util
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDiagnoseContainer(t *testing.T) {
+	container := diagnoseContainer(apiv1.ContainerStatus{
+		Name:         "faas",
+		RestartCount: 3,
+		State: apiv1.ContainerState{
+			Waiting: &apiv1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 40s"},
+		},
+		LastTerminationState: apiv1.ContainerState{
+			Terminated: &apiv1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+		},
+	})
+	if container.State != "waiting" || container.Reason != "CrashLoopBackOff" {
+		t.Errorf("Unexpected state %s (%s)", container.State, container.Reason)
+	}
+	if container.LastTermination != "OOMKilled" {
+		t.Errorf("Expected OOMKilled, got %s", container.LastTermination)
+	}
+	if container.ExitCode == nil || *container.ExitCode != 137 {
+		t.Errorf("Expected exit code 137, got %v", container.ExitCode)
+	}
+}
+
+func TestDiagnosisFatal(t *testing.T) {
+	if reason, fatal := (&Diagnosis{Reasons: []string{"OOMKilled", "CrashLoopBackOff"}}).Fatal(); !fatal || reason != "CrashLoopBackOff" {
+		t.Errorf("Expected CrashLoopBackOff to be fatal, got %q", reason)
+	}
+	if _, fatal := (&Diagnosis{Reasons: []string{"ContainerCreating"}}).Fatal(); fatal {
+		t.Error("Expected ContainerCreating not to be fatal")
+	}
+}
+
+func TestDiagnoseDeployment(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newTestDeployment(),
+		&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "faas-1", Namespace: "default", Labels: map[string]string{"app": "faas"}},
+			Status: apiv1.PodStatus{
+				Phase: apiv1.PodRunning,
+				ContainerStatuses: []apiv1.ContainerStatus{{
+					Name:         "faas",
+					RestartCount: 2,
+					State: apiv1.ContainerState{
+						Waiting: &apiv1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+					},
+					LastTerminationState: apiv1.ContainerState{
+						Terminated: &apiv1.ContainerStateTerminated{Reason: "Error", ExitCode: 1},
+					},
+				}},
+			},
+		},
+		// a pod of another deployment
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other-1", Namespace: "default", Labels: map[string]string{"app": "other"}}},
+		&apiv1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "faas-1.1", Namespace: "default"},
+			InvolvedObject: apiv1.ObjectReference{Kind: "Pod", Name: "faas-1"},
+			Type:           apiv1.EventTypeWarning,
+			Reason:         "Unhealthy",
+			Message:        "Startup probe failed: connection refused",
+		},
+		&apiv1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "other-1.1", Namespace: "default"},
+			InvolvedObject: apiv1.ObjectReference{Kind: "Pod", Name: "other-1"},
+			Reason:         "Pulled",
+		},
+	)
+	// the fake clientset ignores field selectors
+	clientset.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		restrictions := action.(k8stesting.ListAction).GetListRestrictions()
+		events := &apiv1.EventList{}
+		for _, name := range []string{"faas-1", "other-1"} {
+			if restrictions.Fields.Matches(fields.Set{"involvedObject.name": name}) {
+				event, err := clientset.Tracker().Get(apiv1.SchemeGroupVersion.WithResource("events"), "default", name+".1")
+				if err != nil {
+					return true, nil, err
+				}
+				events.Items = append(events.Items, *event.(*apiv1.Event))
+			}
+		}
+		return true, events, nil
+	})
+
+	diagnosis, err := DiagnoseDeployment(context.Background(), clientset, "default", "faas", DefaultLogTailLines)
+	if err != nil {
+		t.Fatalf("DiagnoseDeployment(): %v", err)
+	}
+	if len(diagnosis.Pods) != 1 || diagnosis.Pods[0].Name != "faas-1" {
+		t.Fatalf("Expected the pods of the deployment only, got %+v", diagnosis.Pods)
+	}
+	if len(diagnosis.Events) != 1 || diagnosis.Events[0].Object != "pod/faas-1" {
+		t.Errorf("Expected the events of the pods of the deployment only, got %+v", diagnosis.Events)
+	}
+	if strings.Join(diagnosis.Reasons, ",") != "CrashLoopBackOff,Error,StartupProbeFailed" {
+		t.Errorf("Unexpected reasons %v", diagnosis.Reasons)
+	}
+	if logs := diagnosis.Pods[0].Containers[0].Logs; len(logs) != 1 || logs[0] != "fake logs" {
+		t.Errorf("Expected the log tail, got %v", logs)
+	}
+	logRequests := 0
+	for _, action := range clientset.Actions() {
+		if action.GetSubresource() != "log" {
+			continue
+		}
+		logRequests++
+		if opts := action.(k8stesting.GenericAction).GetValue().(*apiv1.PodLogOptions); !opts.Previous || *opts.TailLines != DefaultLogTailLines {
+			t.Errorf("Expected the tail of the previous instance, got %+v", opts)
+		}
+	}
+	if logRequests != 1 {
+		t.Errorf("Expected the logs to be fetched once, got %d", logRequests)
+	}
+
+	// without a tail, neither logs nor events are fetched
+	diagnosis, err = DiagnoseDeployment(context.Background(), clientset, "default", "faas", 0)
+	if err != nil {
+		t.Fatalf("DiagnoseDeployment(): %v", err)
+	}
+	if len(diagnosis.Events) != 0 || diagnosis.Pods[0].Containers[0].Logs != nil {
+		t.Errorf("Expected no logs nor events without a tail, got %+v", diagnosis)
+	}
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ListEndpoints returns the `{ip}:{port}` of the ready pods behind the Service, from its EndpointSlices, sorted.
+func ListEndpoints(ctx context.Context, clientset kubernetes.Interface, namespace string, serviceName string) ([]string, error) {
+	endpointSlices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", discoveryv1.LabelServiceName, serviceName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("endpointSliceClient.List(%s): %w", serviceName, err)
+	}
+	items := make([]*discoveryv1.EndpointSlice, 0, len(endpointSlices.Items))
+	for i := range endpointSlices.Items {
+		items = append(items, &endpointSlices.Items[i])
+	}
+	return ReadyEndpoints(items...), nil
+}
+
+// ReadyEndpoints returns the `{ip}:{port}` of the ready pods of the EndpointSlices of a Service, sorted.
+func ReadyEndpoints(endpointSlices ...*discoveryv1.EndpointSlice) []string {
+	var endpoints []string
+	for _, slice := range endpointSlices {
+		if len(slice.Ports) == 0 || slice.Ports[0].Port == nil {
+			continue
+		}
+		port := strconv.Itoa(int(*slice.Ports[0].Port))
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				endpoints = append(endpoints, net.JoinHostPort(address, port))
+			}
+		}
+	}
+	slices.Sort(endpoints)
+	return slices.Compact(endpoints)
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListEndpoints(t *testing.T) {
+	port := int32(8000)
+	ready, notReady := true, false
+	clientset := fake.NewClientset(
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "service-1-a", Namespace: "default", Labels: map[string]string{discoveryv1.LabelServiceName: "service-1"}},
+			Ports:      []discoveryv1.EndpointPort{{Port: &port}},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+				{Addresses: []string{"10.0.0.3"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+				{Addresses: []string{"10.0.0.1"}},
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "service-2-a", Namespace: "default", Labels: map[string]string{discoveryv1.LabelServiceName: "service-2"}},
+			Ports:      []discoveryv1.EndpointPort{{Port: &port}},
+			Endpoints:  []discoveryv1.Endpoint{{Addresses: []string{"10.0.1.1"}}},
+		},
+	)
+
+	endpoints, err := ListEndpoints(context.Background(), clientset, "default", "service-1")
+	if err != nil {
+		t.Fatalf("ListEndpoints(): %v", err)
+	}
+	if expected := []string{"10.0.0.1:8000", "10.0.0.2:8000"}; !slices.Equal(endpoints, expected) {
+		t.Errorf("Expected %v, got %v", expected, endpoints)
+	}
+}
@@ -2,11 +2,19 @@ package util
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"poorman-faas/pkg/tracing"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -42,75 +50,166 @@ func K8sExternalDomainName(ctx context.Context, clientset *kubernetes.Clientset,
 	return fmt.Sprintf("https://%s:%d%s/%s", LoadBalancerIP, loadBalancerPort, gatewayPrefix, serviceName), nil
 }
 
-// WaitForServiceHealth waits for the Kubernetes Deployment to become ready by checking
-// the deployment status. It waits up to 60 seconds, checking every 5 seconds.
+// defaultReadinessTimeout is the deadline when the Deployment has no startup probe.
+const defaultReadinessTimeout = 60 * time.Second
+
+// readinessGrace leaves room for scheduling the pod and pulling the image, before the startup probe starts.
+const readinessGrace = 30 * time.Second
+
+// ReadinessTimeout returns how long the Deployment may take to become ready, derived from its startup probes.
+func ReadinessTimeout(deployment *appsv1.Deployment) time.Duration {
+	var timeout time.Duration
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		probe := container.StartupProbe
+		if probe == nil {
+			continue
+		}
+		period := max(probe.PeriodSeconds, 1)
+		failures := max(probe.FailureThreshold, 1)
+		seconds := probe.InitialDelaySeconds + period*failures + probe.TimeoutSeconds
+		timeout = max(timeout, time.Duration(seconds)*time.Second)
+	}
+	if timeout == 0 {
+		return defaultReadinessTimeout
+	}
+	return timeout + readinessGrace
+}
+
+// WaitForServiceHealth waits for the Kubernetes Deployment to become ready, by watching the Deployment and its pods.
 //
 // A deployment is considered ready when the number of available replicas equals the desired replicas
-// (i.e., ReadyReplicas and AvailableReplicas match the desired count). Returns nil if the deployment becomes ready, or an error if it times out.
-func WaitForServiceHealth(ctx context.Context, clientset *kubernetes.Clientset, namespace string, deploymentName string, logger *slog.Logger) error {
-	logger.Info("Waiting for deployment to become ready", "deployment", deploymentName, "namespace", namespace)
+// (i.e., ReadyReplicas and AvailableReplicas match the desired count). Returns nil as soon as the deployment becomes ready,
+// or an error if it times out. The deadline is the earliest of ctx and [ReadinessTimeout].
+// It returns early if a pod is stuck, i.e. in CrashLoopBackOff or ImagePullBackOff, see [DiagnoseDeployment].
+func WaitForServiceHealth(ctx context.Context, clientset kubernetes.Interface, namespace string, deploymentName string, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "util.WaitForServiceHealth", attribute.String("faas.deployment", deploymentName))
+	defer func() { tracing.End(span, err) }()
 
 	deploymentClient := clientset.AppsV1().Deployments(namespace)
+	deployment, err := deploymentClient.Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("deploymentClient.Get(%s): %w", deploymentName, err)
+	}
+	if deployment.Spec.Selector == nil {
+		return fmt.Errorf("deployment %s has no selector", deploymentName)
+	}
+	podSelector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels).String()
 
-	// Wait up to 60 seconds, checking every 5 seconds (12 attempts)
-	maxAttempts := 13
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	timeout := ReadinessTimeout(deployment)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	logger.Info("Waiting for deployment to become ready", "deployment", deploymentName, "namespace", namespace, "timeout", timeout)
 
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		// Check for context cancellation
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("context cancelled while waiting for deployment %s: %w", deploymentName, ctx.Err())
-		default:
+	for {
+		if deploymentReady(deployment, logger) {
+			return nil
 		}
 
-		deployment, err := deploymentClient.Get(ctx, deploymentName, metav1.GetOptions{})
+		// watch from the version we have seen, so that no update is missed in between
+		deploymentWatch, err := deploymentClient.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", deploymentName).String(),
+			ResourceVersion: deployment.ResourceVersion,
+		})
 		if err != nil {
-			logger.Warn("Failed to get deployment status", "error", err, "attempt", attempt)
-			if attempt < maxAttempts {
-				select {
-				case <-ctx.Done():
-					return fmt.Errorf("context cancelled while waiting for deployment %s: %w", deploymentName, ctx.Err())
-				case <-ticker.C:
-				}
-			}
-			continue
+			return waitError(ctx, deploymentName, timeout, fmt.Errorf("deploymentClient.Watch(%s): %w", deploymentName, err))
 		}
-
-		// Check if deployment is ready
-		// A deployment is ready when:
-		// 1. Replicas == ReadyReplicas (all replicas are ready)
-		// 2. AvailableReplicas >= Replicas (replicas are available)
-		// 3. ReadyReplicas > 0 (at least one replica is ready)
-		desiredReplicas := int32(1)
-		if deployment.Spec.Replicas != nil {
-			desiredReplicas = *deployment.Spec.Replicas
+		podWatch, err := clientset.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{LabelSelector: podSelector})
+		if err != nil {
+			deploymentWatch.Stop()
+			return waitError(ctx, deploymentName, timeout, fmt.Errorf("podClient.Watch(): %w", err))
 		}
 
-		logger.Info("Checking deployment status",
-			"deployment", deploymentName,
-			"attempt", attempt,
-			"desired", desiredReplicas,
-			"ready", deployment.Status.ReadyReplicas,
-			"available", deployment.Status.AvailableReplicas,
-			"updated", deployment.Status.UpdatedReplicas,
-		)
-
-		if deployment.Status.ReadyReplicas >= desiredReplicas &&
-			deployment.Status.AvailableReplicas >= desiredReplicas {
-			logger.Info("Deployment is ready", "deployment", deploymentName)
+		deployment, err = watchUntilReady(ctx, deployment, deploymentWatch, podWatch, logger)
+		deploymentWatch.Stop()
+		podWatch.Stop()
+		if err != nil {
+			return waitError(ctx, deploymentName, timeout, err)
+		}
+		if deployment == nil {
 			return nil
 		}
 
-		if attempt < maxAttempts {
-			select {
-			case <-ctx.Done():
-				return fmt.Errorf("context cancelled while waiting for deployment %s: %w", deploymentName, ctx.Err())
-			case <-ticker.C:
+		// a watch was closed by the API server, resync before watching again
+		deployment, err = deploymentClient.Get(ctx, deploymentName, metav1.GetOptions{})
+		if err != nil {
+			return waitError(ctx, deploymentName, timeout, fmt.Errorf("deploymentClient.Get(%s): %w", deploymentName, err))
+		}
+	}
+}
+
+// watchUntilReady consumes the watches until the deployment is ready, in which case it returns nil,
+// or until a watch is closed, in which case it returns the last seen deployment.
+func watchUntilReady(ctx context.Context, deployment *appsv1.Deployment, deploymentWatch watch.Interface, podWatch watch.Interface, logger *slog.Logger) (*appsv1.Deployment, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case event, ok := <-deploymentWatch.ResultChan():
+			if !ok {
+				return deployment, nil
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return nil, fmt.Errorf("deployment %s was deleted", deployment.Name)
+			case watch.Error:
+				return deployment, nil
+			}
+			if updated, isDeployment := event.Object.(*appsv1.Deployment); isDeployment {
+				deployment = updated
+				if deploymentReady(deployment, logger) {
+					return nil, nil
+				}
+			}
+
+		case event, ok := <-podWatch.ResultChan():
+			if !ok {
+				return deployment, nil
+			}
+			pod, isPod := event.Object.(*apiv1.Pod)
+			if !isPod || event.Type == watch.Deleted {
+				continue
+			}
+			// give up early on problems that will not resolve by waiting longer
+			for _, status := range pod.Status.ContainerStatuses {
+				if reason := diagnoseContainer(status).Reason; fatalReasons[reason] {
+					return nil, fmt.Errorf("deployment %s failed: pod %s is in %s", deployment.Name, pod.Name, reason)
+				}
 			}
 		}
 	}
+}
+
+// deploymentReady reports whether all the desired replicas are ready and available.
+func deploymentReady(deployment *appsv1.Deployment, logger *slog.Logger) bool {
+	desiredReplicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desiredReplicas = *deployment.Spec.Replicas
+	}
+
+	logger.Debug("Checking deployment status",
+		"deployment", deployment.Name,
+		"desired", desiredReplicas,
+		"ready", deployment.Status.ReadyReplicas,
+		"available", deployment.Status.AvailableReplicas,
+		"updated", deployment.Status.UpdatedReplicas,
+	)
 
-	return fmt.Errorf("deployment %s did not become ready within 60 seconds", deploymentName)
+	if deployment.Status.ReadyReplicas >= desiredReplicas &&
+		deployment.Status.AvailableReplicas >= desiredReplicas {
+		logger.Info("Deployment is ready", "deployment", deployment.Name)
+		return true
+	}
+	return false
+}
+
+// waitError reports a timeout distinctly from the other failures.
+func waitError(ctx context.Context, deploymentName string, timeout time.Duration, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("deployment %s did not become ready within %s: %w", deploymentName, timeout, ctx.Err())
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("context cancelled while waiting for deployment %s: %w", deploymentName, ctx.Err())
+	}
+	return err
 }
@@ -0,0 +1,106 @@
+package util
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestDeployment() *appsv1.Deployment {
+	labels := map[string]string{"app": "faas"}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "faas", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: apiv1.PodSpec{Containers: []apiv1.Container{{
+					Name: "faas",
+					StartupProbe: &apiv1.Probe{
+						InitialDelaySeconds: 10,
+						PeriodSeconds:       5,
+						TimeoutSeconds:      3,
+						FailureThreshold:    10,
+					},
+				}}},
+			},
+		},
+	}
+}
+
+// waitWithWatchers runs WaitForServiceHealth, and calls update once both watches are established.
+func waitWithWatchers(t *testing.T, clientset *fake.Clientset, update func()) error {
+	t.Helper()
+	watching := make(chan struct{}, 2)
+	clientset.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watching <- struct{}{}
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- WaitForServiceHealth(ctx, clientset, "default", "faas", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}()
+	<-watching
+	<-watching
+	update()
+	return <-done
+}
+
+func TestWaitForServiceHealth(t *testing.T) {
+	t.Run("returns as soon as ready", func(t *testing.T) {
+		deployment := newTestDeployment()
+		clientset := fake.NewClientset(deployment)
+		err := waitWithWatchers(t, clientset, func() {
+			ready := deployment.DeepCopy()
+			ready.Status.ReadyReplicas = 1
+			ready.Status.AvailableReplicas = 1
+			if _, err := clientset.AppsV1().Deployments("default").Update(context.Background(), ready, metav1.UpdateOptions{}); err != nil {
+				t.Errorf("Update(): %v", err)
+			}
+		})
+		if err != nil {
+			t.Errorf("Expected ready, got %v", err)
+		}
+	})
+
+	t.Run("gives up on crash loop", func(t *testing.T) {
+		clientset := fake.NewClientset(newTestDeployment())
+		err := waitWithWatchers(t, clientset, func() {
+			pod := &apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "faas-abc", Namespace: "default", Labels: map[string]string{"app": "faas"}},
+				Status: apiv1.PodStatus{ContainerStatuses: []apiv1.ContainerStatus{{
+					Name:  "faas",
+					State: apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				}}},
+			}
+			if _, err := clientset.CoreV1().Pods("default").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+				t.Errorf("Create(): %v", err)
+			}
+		})
+		if err == nil || !strings.Contains(err.Error(), "CrashLoopBackOff") {
+			t.Errorf("Expected CrashLoopBackOff error, got %v", err)
+		}
+	})
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	if timeout := ReadinessTimeout(newTestDeployment()); timeout != 63*time.Second+readinessGrace {
+		t.Errorf("Expected the startup probe budget plus grace, got %s", timeout)
+	}
+	if timeout := ReadinessTimeout(&appsv1.Deployment{}); timeout != defaultReadinessTimeout {
+		t.Errorf("Expected default timeout, got %s", timeout)
+	}
+}
@@ -0,0 +1,126 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxLogLineSize caps a single log line, longer lines are split.
+const maxLogLineSize = 64 * 1024
+
+// LogOptions selects the container logs to stream, like `kubectl logs`.
+type LogOptions struct {
+	// Follow keeps streaming new lines until the context is cancelled.
+	Follow bool
+	// TailLines is the number of lines from the end of the logs to start with, all lines if 0.
+	TailLines int64
+	// SinceSeconds only returns the lines newer than that, all lines if 0.
+	SinceSeconds int64
+	// Previous returns the logs of the previous container instance, i.e. before a crash.
+	Previous bool
+}
+
+func (o LogOptions) podLogOptions(container string) *apiv1.PodLogOptions {
+	opts := &apiv1.PodLogOptions{
+		Container: container,
+		Follow:    o.Follow,
+		Previous:  o.Previous,
+	}
+	if o.TailLines > 0 {
+		opts.TailLines = &o.TailLines
+	}
+	if o.SinceSeconds > 0 {
+		opts.SinceSeconds = &o.SinceSeconds
+	}
+	return opts
+}
+
+// ErrServiceNotFound is returned when streaming the logs of a service that does not exist.
+var ErrServiceNotFound = errors.New("service not found")
+
+// StreamServiceLogs streams the container logs of all the pods behind the Service, line by line.
+//
+// Lines of different pods are interleaved as they come, emit is called with the name of the pod they come from,
+// and is never called concurrently.
+func StreamServiceLogs(ctx context.Context, clientset kubernetes.Interface, namespace string, serviceName string, opts LogOptions, emit func(pod string, line string)) error {
+	service, err := clientset.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("serviceClient.Get(%s): %w", serviceName, ErrServiceNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("serviceClient.Get(%s): %w", serviceName, err)
+	}
+	if len(service.Spec.Selector) == 0 {
+		return fmt.Errorf("service %s has no selector", serviceName)
+	}
+
+	podClient := clientset.CoreV1().Pods(namespace)
+	pods, err := podClient.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("podClient.List(): %w", err)
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := streamContainerLogs(ctx, clientset, namespace, pod.Name, container.Name, opts, func(line string) {
+					mu.Lock()
+					defer mu.Unlock()
+					emit(pod.Name, line)
+				})
+				if err != nil {
+					mu.Lock()
+					defer mu.Unlock()
+					errs = append(errs, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		// the client went away while following
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+func streamContainerLogs(ctx context.Context, clientset kubernetes.Interface, namespace string, podName string, container string, opts LogOptions, emit func(line string)) error {
+	stream, err := clientset.CoreV1().Pods(namespace).GetLogs(podName, opts.podLogOptions(container)).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("podClient.GetLogs(%s).Stream(): %w", podName, err)
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReaderSize(stream, maxLogLineSize)
+	for {
+		line, _, err := reader.ReadLine()
+		switch {
+		case err == nil:
+			emit(string(line))
+		case errors.Is(err, io.EOF) || ctx.Err() != nil:
+			return nil
+		default:
+			return fmt.Errorf("podClient.GetLogs(%s): %w", podName, err)
+		}
+	}
+}
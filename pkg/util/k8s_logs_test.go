@@ -0,0 +1,46 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStreamServiceLogs(t *testing.T) {
+	labels := map[string]string{"app": "faas"}
+	clientset := fake.NewClientset(
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "service-1", Namespace: "default"},
+			Spec:       apiv1.ServiceSpec{Selector: labels},
+		},
+		&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "faas-a", Namespace: "default", Labels: labels},
+			Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "faas"}}},
+		},
+		&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+			Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: "other"}}},
+		},
+	)
+
+	lines := make(map[string][]string)
+	err := StreamServiceLogs(context.Background(), clientset, "default", "service-1", LogOptions{TailLines: 10}, func(pod string, line string) {
+		lines[pod] = append(lines[pod], line)
+	})
+	if err != nil {
+		t.Fatalf("StreamServiceLogs(): %v", err)
+	}
+	// the fake clientset always responds with "fake logs"
+	if len(lines) != 1 || len(lines["faas-a"]) != 1 || lines["faas-a"][0] != "fake logs" {
+		t.Errorf("Expected the logs of faas-a only, got %v", lines)
+	}
+
+	err = StreamServiceLogs(context.Background(), clientset, "default", "unknown", LogOptions{}, func(string, string) {})
+	if !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Expected ErrServiceNotFound, got %v", err)
+	}
+}